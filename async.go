@@ -0,0 +1,292 @@
+package logrustash
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrHookClosed is returned by `Fire` when the hook was closed.
+	ErrHookClosed = errors.New("logrustash: hook is closed")
+	// ErrFlushTimeout is returned by `Flush` when the queued entries were not written in time.
+	ErrFlushTimeout = errors.New("logrustash: flush timed out")
+	// ErrEntryTooLarge is returned by `Fire` when the formatted entry is larger than the MTU. See `WithMTU`.
+	ErrEntryTooLarge = errors.New("logrustash: entry is larger than the MTU")
+	// ErrNoWriter is returned by `Fire` when the hook has no writer, e.g. when it is created by `New` with a nil writer.
+	ErrNoWriter = errors.New("logrustash: hook has no writer")
+	// ErrNoFormatter is returned by `Fire` when the hook has no formatter.
+	ErrNoFormatter = errors.New("logrustash: hook has no formatter")
+)
+
+// NewAsyncHook returns a new logrus.Hook for Logstash that writes the entries in the background.
+// `Fire` formats the entry and puts it in a queue of `queueSize` entries which is drained
+// to `w` by a worker goroutine, so logging never blocks on a slow Logstash instance.
+// When the queue is full, the entry is dropped and counted. See `Dropped`.
+//
+// Note: `Close` must be called to write the queued entries and to stop the worker.
+func NewAsyncHook(w io.Writer, f logrus.Formatter, queueSize int, opts ...HookOption) *Hook {
+	h := newHook(f, opts...)
+	h.queue = make(chan queuedEntry, queueSize)
+	h.done = make(chan struct{})
+	go h.work()
+	// The queue exists, so the startup marker is queued instead of written by the constructor.
+	h.setWriter(h.wrapWriter(w))
+	return h
+}
+
+// NewAsyncHookWithContext returns a new asynchronous logrus.Hook (see `NewAsyncHook`) which
+// stops when `ctx` is done, as if `Close` was called: the entries fired afterwards are rejected
+// and the worker writes the queued entries before it exits. Once a write fails, the rest of
+// the queued entries are dropped instead of written, so the worker exits quickly.
+// The dropped and failed entries are counted in `Stats`.
+//
+// Note: `Close` must still be called to close the writer.
+func NewAsyncHookWithContext(ctx context.Context, w io.Writer, f logrus.Formatter, queueSize int, opts ...HookOption) *Hook {
+	h := NewAsyncHook(w, f, queueSize, opts...)
+	go func() {
+		select {
+		case <-ctx.Done():
+			atomic.StoreInt32(&h.canceled, 1)
+			h.stopQueue()
+		case <-h.done:
+		}
+	}()
+	return h
+}
+
+// stopQueue rejects the entries fired from now on and closes the queue so the worker exits
+// once it has written the queued entries.
+func (h *Hook) stopQueue() {
+	h.closeMu.Lock()
+	defer h.closeMu.Unlock()
+
+	if !h.closed {
+		h.closed = true
+		close(h.queue)
+	}
+}
+
+// OverflowPolicy decides which entry an asynchronous hook drops when its queue is full.
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the entry which is fired when the queue is full. It is the default.
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the entry at the head of the queue to make room for the fired entry.
+	DropOldest
+)
+
+// WithOverflowPolicy sets the entry an asynchronous hook drops when its queue is full.
+// Dropped entries are counted in `Stats().Dropped` by both policies.
+func WithOverflowPolicy(p OverflowPolicy) HookOption {
+	return func(h *Hook) {
+		h.overflowPolicy = p
+	}
+}
+
+// OverflowHandler decides what an asynchronous hook does with the entry `entry` when its queue is full:
+// `Fire` waits for room in the queue if it returns true, or the entry is dropped if it returns false.
+type OverflowHandler func(entry *logrus.Entry) (block bool)
+
+// WithOverflowHandler sets the handler called by `Fire` when the queue of an asynchronous hook is full,
+// e.g. to apply backpressure to the batch jobs while the latency-sensitive services drop entries.
+// When `fn` returns true, `Fire` waits up to `timeout` for room in the queue and then drops the entry
+// according to the overflow policy. See `WithOverflowPolicy`.
+//
+// Note: `Close` waits for the blocked `Fire` calls to return.
+func WithOverflowHandler(fn OverflowHandler, timeout time.Duration) HookOption {
+	return func(h *Hook) {
+		h.overflowHandler = fn
+		h.overflowTimeout = timeout
+	}
+}
+
+// queuedEntry is an entry waiting in the queue of an asynchronous hook.
+type queuedEntry struct {
+	// entry is a copy of the fired entry, kept only for the hook's error handler.
+	entry  *logrus.Entry
+	writer io.Writer
+	data   []byte
+	// marker is true if the entry is the startup marker. See `WithStartupMarker`.
+	marker bool
+}
+
+// enqueue puts the entry `e`, formatted to `p`, in the queue of the entries to write to `w`
+// without blocking. If the queue is full, the entry is dropped. `marker` is true for the startup marker.
+func (h *Hook) enqueue(e *logrus.Entry, w io.Writer, p []byte, marker bool) error {
+	h.closeMu.RLock()
+	defer h.closeMu.RUnlock()
+
+	if h.closed {
+		return ErrHookClosed
+	}
+	// The entry is counted as pending before it is queued so the worker never sees a negative count.
+	h.addPending(1)
+	qe := queuedEntry{writer: w, data: p, marker: marker}
+	if h.onError != nil {
+		// logrus may re-use the fired entry once Fire returns.
+		ec := *e
+		qe.entry = &ec
+	}
+	select {
+	case h.queue <- qe:
+		return nil
+	default:
+	}
+	// The worker doesn't need closeMu, so it keeps draining the queue while the handler runs or Fire waits.
+	if h.overflowHandler != nil && h.overflowHandler(e) {
+		timer := time.NewTimer(h.overflowTimeout)
+		select {
+		case h.queue <- qe:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+	if h.overflowPolicy == DropOldest {
+		// Another entry may be queued or dequeued concurrently, so neither step blocks.
+		select {
+		case <-h.queue:
+			h.addPending(-1)
+			atomic.AddUint64(&h.stats.dropped, 1)
+		default:
+		}
+		select {
+		case h.queue <- qe:
+			return nil
+		default:
+		}
+	}
+	h.addPending(-1)
+	atomic.AddUint64(&h.stats.dropped, 1)
+	return nil
+}
+
+// work writes the queued entries to the hook's writer until the queue is closed.
+func (h *Hook) work() {
+	defer close(h.done)
+	drop := false
+	for qe := range h.queue {
+		if drop {
+			atomic.AddUint64(&h.stats.dropped, 1)
+			h.addPending(-1)
+			continue
+		}
+		if err := h.write(qe.writer, qe.data); err != nil {
+			h.reportError(qe.entry, err)
+			// After the context of the hook is done, the worker gives up on the queue.
+			drop = atomic.LoadInt32(&h.canceled) == 1
+		} else if qe.marker {
+			atomic.StoreInt32(&h.marker.sent, 1)
+		}
+		// The write may have redialed the connection. The worker writes the marker itself
+		// since it can't wait for room in its own queue.
+		h.sendStartupMarker(false)
+		h.addPending(-1)
+	}
+}
+
+// addPending adds `n` to the number of queued entries which are not written yet
+// and wakes up the `Flush` callers when there are none left.
+func (h *Hook) addPending(n int) {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+
+	h.pending += n
+	if h.pending == 0 && h.idle != nil {
+		close(h.idle)
+		h.idle = nil
+	}
+}
+
+// Flush blocks until all the queued entries are written or `timeout` elapses,
+// in which case `ErrFlushTimeout` is returned.
+// The current batch of a hook created by `NewBatchHook` is written as well.
+// Call it before a short-lived program exits to make sure its last entries reach Logstash.
+// It is a no-op for other synchronous hooks.
+func (h *Hook) Flush(timeout time.Duration) error {
+	if err := h.waitQueue(timeout); err != nil {
+		return err
+	}
+	return h.flushWriters()
+}
+
+// Drain blocks until all the queued entries are written or `ctx` is done, in which case the error
+// of `ctx` is returned, e.g. to make sure the entries reach Logstash before a risky operation.
+// Like `Flush`, the current batch of a hook created by `NewBatchHook` is written as well.
+// Unlike `Close`, the hook keeps running afterwards. It is a no-op for other synchronous hooks.
+func (h *Hook) Drain(ctx context.Context) error {
+	select {
+	case <-h.idleChan():
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return h.flushWriters()
+}
+
+// flushWriters flushes the writers of the hook which buffer the entries.
+func (h *Hook) flushWriters() error {
+	var errs []error
+	for _, w := range h.writers() {
+		if f, ok := w.(flusher); ok {
+			errs = append(errs, f.Flush())
+		}
+	}
+	return joinErrors(errs...)
+}
+
+// waitQueue blocks until all the queued entries are written or `timeout` elapses.
+func (h *Hook) waitQueue(timeout time.Duration) error {
+	idle := h.idleChan()
+	select {
+	case <-idle:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return nil
+	case <-timer.C:
+		return ErrFlushTimeout
+	}
+}
+
+// closedChan is a closed channel, which never blocks.
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// idleChan returns a channel which is closed once all the queued entries are written.
+func (h *Hook) idleChan() <-chan struct{} {
+	if h.queue == nil {
+		return closedChan
+	}
+
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+
+	if h.pending == 0 {
+		return closedChan
+	}
+	if h.idle == nil {
+		h.idle = make(chan struct{})
+	}
+	return h.idle
+}
+
+// Dropped returns the number of entries dropped without being written: the entries dropped because
+// the queue was full or the context of the hook was done, and the entries dropped by the sampling,
+// the rate limit, the byte budget and the MTU. It is the same as `Stats().Dropped`.
+func (h *Hook) Dropped() uint64 {
+	return atomic.LoadUint64(&h.stats.dropped)
+}
@@ -0,0 +1,307 @@
+package logrustash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// blockingWriter blocks every write until `release` is closed.
+type blockingWriter struct {
+	release chan struct{}
+	mu      sync.Mutex
+	buffer  bytes.Buffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buffer.Write(p)
+}
+
+func TestAsyncHookWritesOnClose(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := NewAsyncHook(buffer, simpleFmter{}, 10)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Errorf("expected Fire to not return error: %s", err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+
+	expected := `msg: "a"msg: "b"msg: "c"`
+	if buffer.String() != expected {
+		t.Errorf("expected to see '%s' in '%s'", expected, buffer.String())
+	}
+	if err := h.Fire(&logrus.Entry{Message: "d", Data: logrus.Fields{}}); err != ErrHookClosed {
+		t.Errorf("expected Fire to return ErrHookClosed after Close but got %v", err)
+	}
+}
+
+func TestAsyncHookDropsWhenQueueIsFull(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h := NewAsyncHook(w, simpleFmter{}, 1)
+
+	// The worker takes at most one entry and the queue holds another one,
+	// so from ten entries at least eight are dropped.
+	for i := 0; i < 10; i++ {
+		if err := h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}}); err != nil {
+			t.Errorf("expected Fire to not return error: %s", err)
+		}
+	}
+	if h.Dropped() < 8 {
+		t.Errorf("expected at least 8 dropped entries but got %d", h.Dropped())
+	}
+
+	close(w.release)
+	h.Close()
+	if written := uint64(bytes.Count(w.buffer.Bytes(), []byte("msg:"))); written+h.Dropped() != 10 {
+		t.Errorf("expected %d written entries and %d dropped entries to sum up to 10", written, h.Dropped())
+	}
+}
+
+func TestAsyncHookDropOldest(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h := NewAsyncHook(w, simpleFmter{}, 2, WithOverflowPolicy(DropOldest))
+
+	for i := 0; i < 10; i++ {
+		if err := h.Fire(&logrus.Entry{Message: fmt.Sprint(i), Data: logrus.Fields{}}); err != nil {
+			t.Errorf("expected Fire to not return error: %s", err)
+		}
+	}
+
+	close(w.release)
+	h.Close()
+	// The worker may have taken one of the first entries before the queue was full.
+	if !strings.HasSuffix(w.buffer.String(), `msg: "8"msg: "9"`) {
+		t.Errorf("expected the newest entries to be written but got '%s'", w.buffer.String())
+	}
+	if written := uint64(bytes.Count(w.buffer.Bytes(), []byte("msg:"))); written+h.Dropped() != 10 {
+		t.Errorf("expected %d written entries and %d dropped entries to sum up to 10", written, h.Dropped())
+	}
+}
+
+func TestAsyncHookOverflowHandlerBlocks(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	block := func(*logrus.Entry) bool { return true }
+	h := NewAsyncHook(w, simpleFmter{}, 1, WithOverflowHandler(block, 5*time.Second))
+
+	fired := make(chan struct{})
+	go func() {
+		defer close(fired)
+		for i := 0; i < 5; i++ {
+			h.Fire(&logrus.Entry{Message: fmt.Sprint(i), Data: logrus.Fields{}})
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(w.release)
+	<-fired
+	h.Close()
+
+	expected := `msg: "0"msg: "1"msg: "2"msg: "3"msg: "4"`
+	if w.buffer.String() != expected {
+		t.Errorf("expected to see '%s' in '%s'", expected, w.buffer.String())
+	}
+	if h.Dropped() != 0 {
+		t.Errorf("expected no dropped entries but got %d", h.Dropped())
+	}
+}
+
+func TestAsyncHookOverflowHandlerTimeout(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	var called []string
+	handler := func(e *logrus.Entry) bool {
+		called = append(called, e.Message)
+		return e.Message != "drop"
+	}
+	h := NewAsyncHook(w, simpleFmter{}, 1, WithOverflowHandler(handler, 10*time.Millisecond))
+
+	// The worker takes the first entry and blocks on it, the second one fills the queue.
+	for _, msg := range []string{"a", "b", "drop", "wait"} {
+		h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}})
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(w.release)
+	h.Close()
+
+	if h.Dropped() != 2 {
+		t.Errorf("expected 2 dropped entries but got %d", h.Dropped())
+	}
+	if strings.Join(called, ",") != "drop,wait" {
+		t.Errorf("expected the handler to be called with the overflowing entries but got %v", called)
+	}
+}
+
+func TestAsyncHookCopiesEntryBuffer(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h := NewAsyncHook(w, DefaultFormatter(logrus.Fields{}), 10)
+
+	buffer := &bytes.Buffer{}
+	h.Fire(&logrus.Entry{Message: "hello", Data: logrus.Fields{}, Buffer: buffer})
+	// logrus re-uses the buffer for the next entries.
+	buffer.Reset()
+	buffer.WriteString("overwritten overwritten overwritten overwritten overwritten overwritten")
+	close(w.release)
+	h.Close()
+
+	if !strings.Contains(w.buffer.String(), `"message":"hello"`) {
+		t.Errorf("expected the queued entry to not be changed with the entry buffer but got '%s'", w.buffer.String())
+	}
+}
+
+func TestAsyncHookFlush(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h := NewAsyncHook(w, simpleFmter{}, 10)
+	defer h.Close()
+
+	for _, msg := range []string{"a", "b"} {
+		h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}})
+	}
+	if err := h.Flush(10 * time.Millisecond); err != ErrFlushTimeout {
+		t.Errorf("expected Flush to return ErrFlushTimeout while the writer is blocked but got %v", err)
+	}
+
+	close(w.release)
+	if err := h.Flush(5 * time.Second); err != nil {
+		t.Errorf("expected Flush to not return error: %s", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	expected := `msg: "a"msg: "b"`
+	if w.buffer.String() != expected {
+		t.Errorf("expected to see '%s' in '%s'", expected, w.buffer.String())
+	}
+}
+
+func TestAsyncHookDrain(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	h := NewAsyncHook(w, simpleFmter{}, 10)
+	defer h.Close()
+
+	for _, msg := range []string{"a", "b"} {
+		h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := h.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected Drain to return the error of the context while the writer is blocked but got %v", err)
+	}
+
+	close(w.release)
+	if err := h.Drain(context.Background()); err != nil {
+		t.Errorf("expected Drain to not return error: %s", err)
+	}
+	w.mu.Lock()
+	if w.buffer.String() != `msg: "a"msg: "b"` {
+		t.Errorf("expected the queued entries to be written but got '%s'", w.buffer.String())
+	}
+	w.mu.Unlock()
+
+	// The hook keeps running after Drain.
+	if err := h.Fire(&logrus.Entry{Message: "c", Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error after Drain: %s", err)
+	}
+	if err := h.Drain(context.Background()); err != nil {
+		t.Errorf("expected Drain to not return error: %s", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.buffer.String() != `msg: "a"msg: "b"msg: "c"` {
+		t.Errorf("expected the entry fired after Drain to be written but got '%s'", w.buffer.String())
+	}
+}
+
+func TestFlushSyncHook(t *testing.T) {
+	h := New(bytes.NewBuffer(nil), simpleFmter{})
+	if err := h.Flush(0); err != nil {
+		t.Errorf("expected Flush to be a no-op for a synchronous hook but got %s", err)
+	}
+}
+
+func TestAsyncHookOnError(t *testing.T) {
+	errs := make(chan error, 1)
+	var failed *logrus.Entry
+	h := NewAsyncHook(FailWrite{}, simpleFmter{}, 10, OnError(func(e *logrus.Entry, err error) {
+		failed = e
+		errs <- err
+	}))
+
+	if err := h.Fire(&logrus.Entry{Message: "lost", Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error: %s", err)
+	}
+	h.Close()
+
+	select {
+	case <-errs:
+		if failed == nil || failed.Message != "lost" {
+			t.Errorf("expected the failed entry to be reported but got %#v", failed)
+		}
+	default:
+		t.Error("expected the write error to be reported")
+	}
+}
+
+func TestAsyncHookWithContext(t *testing.T) {
+	buffer := &recordWriter{}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewAsyncHookWithContext(ctx, buffer, simpleFmter{}, 10)
+
+	if err := h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error: %s", err)
+	}
+	cancel()
+	<-h.done
+
+	if err := h.Fire(&logrus.Entry{Message: "b", Data: logrus.Fields{}}); err != ErrHookClosed {
+		t.Errorf("expected Fire to return ErrHookClosed after the context is canceled but got %v", err)
+	}
+	if writes := buffer.Writes(); len(writes) != 1 || writes[0] != `msg: "a"` {
+		t.Errorf("expected the queued entry to be written but got %#v", writes)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+}
+
+func TestAsyncHookWithContextDropsAfterFailure(t *testing.T) {
+	w := &failingWriter{release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := NewAsyncHookWithContext(ctx, w, simpleFmter{}, 10)
+
+	for i := 0; i < 5; i++ {
+		h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+	}
+	cancel()
+	for atomic.LoadInt32(&h.canceled) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(w.release)
+	<-h.done
+
+	stats := h.Stats()
+	if stats.Failed != 1 || stats.Dropped != 4 {
+		t.Errorf("expected 1 failed and 4 dropped entries but got %+v", stats)
+	}
+}
+
+// failingWriter blocks every write until `release` is closed and then fails it.
+type failingWriter struct {
+	release chan struct{}
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return 0, errors.New("connection refused")
+}
@@ -0,0 +1,77 @@
+package logrustash
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewBalancedHook returns a new logrus.Hook for Logstash that spreads the entries over TCP
+// across `addresses` in round-robin order.
+// When writing an entry to an instance fails, it is written to the next instance once.
+// The instances are dialed on their first write and redialed, with the backoff of
+// `WithReconnectBackoff`, after a failed write, so an instance is never removed from the rotation.
+//
+// hook, err := logrustash.NewBalancedHook([]string{"logstash1.corp.io:9999", "logstash2.corp.io:9999"}, logrustash.DefaultFormatter(logrus.Fields{}))
+func NewBalancedHook(addresses []string, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	h := newHook(f, withStreamDefaults(opts)...)
+	w, err := newBalancedWriter("tcp", addresses, h.dialer().Dial, h.connConfig())
+	if err != nil {
+		return nil, err
+	}
+	h.setWriter(h.wrapWriter(w))
+	return h, nil
+}
+
+// balancedWriter writes to its connections in round-robin order.
+type balancedWriter struct {
+	// next is accessed atomically.
+	next  uint32
+	conns []*conn
+}
+
+func newBalancedWriter(network string, addresses []string, d dialFunc, cfg connConfig) (*balancedWriter, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoAddress
+	}
+	conns := make([]*conn, len(addresses))
+	for i, address := range addresses {
+		conns[i] = &conn{network: network, address: address, dial: d, connConfig: cfg}
+	}
+	return &balancedWriter{conns: conns}, nil
+}
+
+// Write writes `p` to the next connection and, if it fails, to the one after it.
+func (w *balancedWriter) Write(p []byte) (int, error) {
+	i := int((atomic.AddUint32(&w.next, 1) - 1) % uint32(len(w.conns)))
+	n, err := w.conns[i].Write(p)
+	if err == nil || len(w.conns) == 1 {
+		return n, err
+	}
+	return w.conns[(i+1)%len(w.conns)].Write(p)
+}
+
+// RemoteAddr returns the remote address of the connection the last entry was written to.
+func (w *balancedWriter) RemoteAddr() net.Addr {
+	n := atomic.LoadUint32(&w.next)
+	if n > 0 {
+		n--
+	}
+	return w.conns[n%uint32(len(w.conns))].RemoteAddr()
+}
+
+// Ping checks the connections and returns an error only if none of them is usable.
+func (w *balancedWriter) Ping() error {
+	return pingAny(w.conns)
+}
+
+// Reconnect dials all the connections again.
+func (w *balancedWriter) Reconnect() error {
+	return reconnectConns(w.conns)
+}
+
+// Close closes all the connections.
+func (w *balancedWriter) Close() error {
+	return closeAll(w.conns)
+}
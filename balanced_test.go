@@ -0,0 +1,56 @@
+package logrustash
+
+import (
+	"testing"
+)
+
+func TestBalancedWriter(t *testing.T) {
+	d := newFakeDialer()
+	w, err := newBalancedWriter("tcp", []string{"a", "b", "c"}, d.dial, connConfig{})
+	if err != nil {
+		t.Fatalf("expected newBalancedWriter to not return error: %s", err)
+	}
+	if len(d.conns) != 0 {
+		t.Errorf("expected the instances to be dialed lazily but %d were dialed", len(d.conns))
+	}
+
+	for _, p := range []string{"1", "2", "3", "4"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Errorf("expected Write to not return error: %s", err)
+		}
+	}
+	for address, expected := range map[string]string{"a": "14", "b": "2", "c": "3"} {
+		if d.conns[address].buffer.String() != expected {
+			t.Errorf("expected '%s' to be written to '%s' but got '%s'", expected, address, d.conns[address].buffer.String())
+		}
+	}
+
+	// "b" is down, so its entry is written to "c".
+	d.conns["b"].broken = true
+	d.down["b"] = true
+	for _, p := range []string{"5", "6"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Errorf("expected Write to not return error: %s", err)
+		}
+	}
+	if d.conns["c"].buffer.String() != "356" {
+		t.Errorf("expected '356' to be written to 'c' but got '%s'", d.conns["c"].buffer.String())
+	}
+
+	// "b" is back and is redialed on its turn.
+	d.down["b"] = false
+	for _, p := range []string{"7", "8"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Errorf("expected Write to not return error: %s", err)
+		}
+	}
+	if d.conns["b"].buffer.String() != "8" {
+		t.Errorf("expected '8' to be written to the redialed 'b' but got '%s'", d.conns["b"].buffer.String())
+	}
+}
+
+func TestNewBalancedHookWithoutAddress(t *testing.T) {
+	if _, err := NewBalancedHook([]string{}, simpleFmter{}); err != ErrNoAddress {
+		t.Errorf("expected NewBalancedHook to return ErrNoAddress but got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package logrustash
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewBatchHook returns a new logrus.Hook for Logstash that writes the entries in batches.
+// The formatted entries are accumulated and written to `w` as a single newline-delimited payload
+// when the batch reaches `maxBytes` or every `interval`, whichever comes first.
+// If `interval` is 0, the batches are written only when they are full and by `Flush`.
+// An entry which is larger than `maxBytes` is written on its own.
+// With `FramingLengthPrefix`, the length-prefixed entries are written without newlines.
+//
+// Note: `Close` must be called to write the last partial batch.
+func NewBatchHook(w io.Writer, f logrus.Formatter, maxBytes int, interval time.Duration, opts ...HookOption) *Hook {
+	h := newHook(f, opts...)
+	b := newBatchWriter(h.wrapWriter(w), maxBytes, interval, func(err error) {
+		h.reportError(nil, err)
+	})
+	b.stats = &h.stats
+	b.framed = h.framing == FramingLengthPrefix
+	h.setWriter(b)
+	return h
+}
+
+// NewJSONArrayBatchHook returns a new logrus.Hook for Logstash that writes the entries in batches
+// encoded as JSON arrays, e.g. for the Logstash HTTP input. The entries are accumulated and written
+// to `w` as a single JSON array when the batch has `maxEntries` entries or every `interval`,
+// whichever comes first, or only when they are full and by `Flush` if `interval` is 0.
+// `f` must format the entries to JSON objects. Their trailing newline, if any, is removed.
+//
+// Note: `Close` must be called to write the last partial batch.
+func NewJSONArrayBatchHook(w io.Writer, f logrus.Formatter, maxEntries int, interval time.Duration, opts ...HookOption) *Hook {
+	h := newHook(f, opts...)
+	b := newBatchWriter(h.wrapWriter(w), 0, interval, func(err error) {
+		h.reportError(nil, err)
+	})
+	b.stats = &h.stats
+	b.maxEntries = maxEntries
+	h.setWriter(b)
+	return h
+}
+
+// flusher is implemented by the writers which buffer data before writing it, like the batch writer.
+type flusher interface {
+	Flush() error
+}
+
+// batchWriter accumulates the written entries and writes them to `w` in batches.
+type batchWriter struct {
+	w        io.Writer
+	maxBytes int
+	// onError is called when a periodic write fails.
+	onError func(error)
+	// stats, if set, counts the entries of every written batch.
+	stats *hookStats
+	// framed is true when the entries are already delimited and are not terminated by a newline.
+	framed bool
+	// maxEntries, if set, is the number of entries of a batch, which is then written as a JSON array.
+	maxEntries int
+
+	mu      sync.Mutex
+	buffer  bytes.Buffer
+	entries int
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newBatchWriter(w io.Writer, maxBytes int, interval time.Duration, onError func(error)) *batchWriter {
+	b := &batchWriter{
+		w:        w,
+		maxBytes: maxBytes,
+		onError:  onError,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if interval > 0 {
+		go b.tick(interval)
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// Write adds the entry `p` to the current batch, terminated by a newline.
+// The batch is written first if `p` does not fit in it and it is written right away once it is full.
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.maxEntries > 0 {
+		return b.writeArrayEntry(p)
+	}
+	size := len(p)
+	terminated := b.framed || (size > 0 && p[size-1] == '\n')
+	if !terminated {
+		size++
+	}
+	if b.buffer.Len() > 0 && b.buffer.Len()+size > b.maxBytes {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	}
+	b.buffer.Write(p)
+	if !terminated {
+		b.buffer.WriteByte('\n')
+	}
+	b.entries++
+	if b.buffer.Len() >= b.maxBytes {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// writeArrayEntry adds the entry `p` to the current JSON array batch, which is written right away
+// once it has `maxEntries` entries. It must be called with `mu` held.
+func (b *batchWriter) writeArrayEntry(p []byte) (int, error) {
+	if b.buffer.Len() == 0 {
+		b.buffer.WriteByte('[')
+	} else {
+		b.buffer.WriteByte(',')
+	}
+	b.buffer.Write(bytes.TrimRight(p, "\r\n"))
+	b.entries++
+	if b.entries >= b.maxEntries {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the current batch.
+func (b *batchWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flush()
+}
+
+// Close stops the periodic writes, writes the last batch and closes `w` if it implements io.Closer.
+func (b *batchWriter) Close() error {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	<-b.done
+
+	err := b.Flush()
+	if c, ok := b.w.(io.Closer); ok {
+		return joinErrors(err, c.Close())
+	}
+	return err
+}
+
+// RemoteAddr returns the remote address of `w`, if it has one.
+func (b *batchWriter) RemoteAddr() net.Addr {
+	return remoteAddr(b.w)
+}
+
+// Ping checks the connection of `w`, if it has one.
+func (b *batchWriter) Ping() error {
+	return ping(b.w)
+}
+
+// Reconnect dials the connection of `w` again, if it has one.
+func (b *batchWriter) Reconnect() error {
+	return reconnect(b.w)
+}
+
+// flush writes the current batch. The batch is discarded even if writing it fails.
+// It must be called with `mu` held.
+func (b *batchWriter) flush() error {
+	if b.buffer.Len() == 0 {
+		return nil
+	}
+	if b.maxEntries > 0 {
+		b.buffer.WriteByte(']')
+	}
+	_, err := b.w.Write(b.buffer.Bytes())
+	if b.stats != nil {
+		b.stats.written(b.entries, err)
+	}
+	b.buffer.Reset()
+	b.entries = 0
+	return err
+}
+
+// tick writes the current batch every `interval` until the writer is closed.
+func (b *batchWriter) tick(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Flush releases the lock before the error is reported.
+			if err := b.Flush(); err != nil && b.onError != nil {
+				b.onError(err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordWriter records every write it gets.
+type recordWriter struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *recordWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func (w *recordWriter) Writes() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.writes...)
+}
+
+func TestBatchHookWritesFullBatch(t *testing.T) {
+	w := &recordWriter{}
+	// Every entry is 11 bytes with its newline so two entries fill a batch of 22 bytes.
+	h := NewBatchHook(w, simpleFmter{}, 22, time.Hour)
+
+	for _, msg := range []string{"aaa", "bbb", "ccc"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Errorf("expected Fire to not return error: %s", err)
+		}
+	}
+	writes := w.Writes()
+	if len(writes) != 1 || writes[0] != "msg: \"aaa\"\nmsg: \"bbb\"\n" {
+		t.Errorf("expected the first two entries to be written in one batch but got %#v", writes)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+	writes = w.Writes()
+	if len(writes) != 2 || writes[1] != "msg: \"ccc\"\n" {
+		t.Errorf("expected Close to write the partial batch but got %#v", writes)
+	}
+}
+
+func TestBatchHookWritesOversizedEntryAlone(t *testing.T) {
+	w := &recordWriter{}
+	h := NewBatchHook(w, simpleFmter{}, 20, time.Hour)
+	defer h.Close()
+
+	h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}})
+	h.Fire(&logrus.Entry{Message: "this message does not fit in a batch", Data: logrus.Fields{}})
+
+	writes := w.Writes()
+	expected := []string{"msg: \"a\"\n", "msg: \"this message does not fit in a batch\"\n"}
+	if len(writes) != len(expected) {
+		t.Fatalf("expected %#v but got %#v", expected, writes)
+	}
+	for i := range expected {
+		if writes[i] != expected[i] {
+			t.Errorf("expected write %d to be '%s' but got '%s'", i, expected[i], writes[i])
+		}
+	}
+}
+
+func TestBatchHookWritesOnInterval(t *testing.T) {
+	w := &recordWriter{}
+	h := NewBatchHook(w, simpleFmter{}, 1024, 10*time.Millisecond)
+	defer h.Close()
+
+	h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(w.Writes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if writes := w.Writes(); len(writes) != 1 || writes[0] != "msg: \"a\"\n" {
+		t.Errorf("expected the batch to be written after the interval but got %#v", writes)
+	}
+}
+
+func TestBatchHookWithoutInterval(t *testing.T) {
+	for _, h := range []*Hook{
+		NewBatchHook(&recordWriter{}, simpleFmter{}, 1024, 0),
+		NewJSONArrayBatchHook(&recordWriter{}, simpleFmter{}, 10, 0),
+	} {
+		w := h.writer.(*batchWriter).w.(*recordWriter)
+		h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}})
+		time.Sleep(20 * time.Millisecond)
+		if writes := w.Writes(); len(writes) != 0 {
+			t.Errorf("expected the batch to not be written without an interval but got %#v", writes)
+		}
+
+		if err := h.Flush(time.Second); err != nil {
+			t.Errorf("expected Flush to not return error: %s", err)
+		}
+		if writes := w.Writes(); len(writes) != 1 {
+			t.Errorf("expected Flush to write the batch but got %#v", writes)
+		}
+		if err := h.Close(); err != nil {
+			t.Errorf("expected Close to not return error: %s", err)
+		}
+	}
+}
+
+func TestBatchHookOnError(t *testing.T) {
+	errs := make(chan error, 10)
+	h := NewBatchHook(FailWrite{}, simpleFmter{}, 1024, 10*time.Millisecond, OnError(func(e *logrus.Entry, err error) {
+		if e != nil {
+			t.Errorf("expected no entry for a failed batch but got %#v", e)
+		}
+		errs <- err
+	}))
+	defer h.Close()
+
+	h.Fire(&logrus.Entry{Message: "lost", Data: logrus.Fields{}})
+
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the periodic write error to be reported")
+	}
+}
+
+func TestJSONArrayBatchHook(t *testing.T) {
+	w := &recordWriter{}
+	h := NewJSONArrayBatchHook(w, DefaultFormatter(logrus.Fields{}, WithOrderedKeys()), 2, time.Hour)
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Errorf("expected Fire to not return error: %s", err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+
+	writes := w.Writes()
+	if len(writes) != 2 {
+		t.Fatalf("expected a full batch and a partial batch but got %#v", writes)
+	}
+	for i, expected := range [][]string{{"a", "b"}, {"c"}} {
+		var entries []map[string]interface{}
+		if err := json.Unmarshal([]byte(writes[i]), &entries); err != nil {
+			t.Fatalf("expected batch %d to be a JSON array but got '%s': %s", i, writes[i], err)
+		}
+		if len(entries) != len(expected) {
+			t.Fatalf("expected batch %d to have %d entries but got '%s'", i, len(expected), writes[i])
+		}
+		for j, msg := range expected {
+			if entries[j]["message"] != msg {
+				t.Errorf("expected entry %d of batch %d to be '%s' but got %v", j, i, msg, entries[j]["message"])
+			}
+		}
+	}
+	if s := h.Stats(); s.Sent != 3 {
+		t.Errorf("expected 3 written entries but got %d", s.Sent)
+	}
+}
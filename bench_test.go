@@ -0,0 +1,67 @@
+package logrustash
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// benchmarkEntry returns an entry with a representative set of fields.
+func benchmarkEntry() *logrus.Entry {
+	return &logrus.Entry{
+		Message: "request served",
+		Level:   logrus.InfoLevel,
+		Time:    time.Now(),
+		Data: logrus.Fields{
+			"method":     "GET",
+			"path":       "/api/v1/users/42",
+			"status":     200,
+			"latency_ms": 12.5,
+			"user_id":    42,
+			"cached":     false,
+			"error":      errors.New("upstream timeout"),
+		},
+	}
+}
+
+func BenchmarkFire(b *testing.B) {
+	h := New(ioutil.Discard, DefaultFormatter(logrus.Fields{"app": "walrus"}))
+	e := benchmarkEntry()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := h.Fire(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDefaultFormatter(b *testing.B) {
+	formatter := DefaultFormatter(logrus.Fields{"app": "walrus"})
+	e := benchmarkEntry()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := formatter.Format(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDefaultFormatterOrderedKeys(b *testing.B) {
+	formatter := DefaultFormatter(logrus.Fields{"app": "walrus"}, WithOrderedKeys())
+	e := benchmarkEntry()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := formatter.Format(e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package logrustash
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// WithBufferedWriter buffers the writes of the hook in a buffer of `size` bytes, so several small
+// entries are written to the writer at once, and flushes the buffer every `flushInterval` so
+// the entries are not delayed for longer. A zero interval disables the periodic flushes.
+// Unlike `NewBatchHook`, the entries are not delimited by the hook, and a write is counted
+// in `Stats` when the entry is buffered.
+// `Close` writes the buffered entries. The failed periodic writes are reported to `OnError`
+// with a nil entry, and the entries they contained are discarded.
+func WithBufferedWriter(size int, flushInterval time.Duration) HookOption {
+	return func(h *Hook) {
+		h.bufferSize = size
+		h.bufferInterval = flushInterval
+	}
+}
+
+// bufferedWriter buffers the data written to it and writes it to `w`
+// when the buffer is full, every interval or when it is flushed.
+type bufferedWriter struct {
+	w io.Writer
+	// onError is called when a periodic write fails.
+	onError func(error)
+
+	mu   sync.Mutex
+	buf  *bufio.Writer
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newBufferedWriter(w io.Writer, size int, interval time.Duration, onError func(error)) *bufferedWriter {
+	b := &bufferedWriter{
+		w:       w,
+		onError: onError,
+		buf:     bufio.NewWriterSize(w, size),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if interval > 0 {
+		go b.tick(interval)
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// Write adds `p` to the buffer, which is written first if `p` does not fit in it.
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n, err := b.buf.Write(p)
+	if err != nil {
+		// bufio.Writer keeps failing after an error, so the buffer is discarded to recover
+		// once the writer works again, e.g. when the connection is redialed.
+		b.buf.Reset(b.w)
+	}
+	return n, err
+}
+
+// Flush writes the buffered data and flushes `w` if it buffers data too.
+func (b *bufferedWriter) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.flush(); err != nil {
+		return err
+	}
+	if f, ok := b.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// flush writes the buffered data. The data is discarded even if writing it fails.
+// It must be called with `mu` held.
+func (b *bufferedWriter) flush() error {
+	err := b.buf.Flush()
+	if err != nil {
+		b.buf.Reset(b.w)
+	}
+	return err
+}
+
+// Close stops the periodic writes, writes the buffered data and closes `w` if it implements io.Closer.
+func (b *bufferedWriter) Close() error {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	<-b.done
+
+	b.mu.Lock()
+	err := b.flush()
+	b.mu.Unlock()
+	if c, ok := b.w.(io.Closer); ok {
+		return joinErrors(err, c.Close())
+	}
+	return err
+}
+
+// RemoteAddr returns the remote address of `w`, if it has one.
+func (b *bufferedWriter) RemoteAddr() net.Addr {
+	return remoteAddr(b.w)
+}
+
+// Ping checks the connection of `w`, if it has one.
+func (b *bufferedWriter) Ping() error {
+	return ping(b.w)
+}
+
+// Reconnect dials the connection of `w` again, if it has one.
+func (b *bufferedWriter) Reconnect() error {
+	return reconnect(b.w)
+}
+
+// tick writes the buffered data every `interval` until the writer is closed.
+func (b *bufferedWriter) tick(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			err := b.flush()
+			b.mu.Unlock()
+			if err != nil && b.onError != nil {
+				b.onError(err)
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
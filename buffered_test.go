@@ -0,0 +1,94 @@
+package logrustash
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// countingConn is a fakeConn which counts its writes.
+type countingConn struct {
+	fakeConn
+	mu     sync.Mutex
+	writes int
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	return c.fakeConn.Write(p)
+}
+
+func (c *countingConn) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buffer.String()
+}
+
+func TestHookWithBufferedWriter(t *testing.T) {
+	conn := &countingConn{fakeConn: fakeConn{buffer: &bytes.Buffer{}}}
+	h := New(conn, simpleFmter{}, WithBufferedWriter(1024, 0))
+
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if conn.String() != "" {
+		t.Errorf("expected nothing to be written before Close but got '%s'", conn.String())
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+
+	expected := `msg: "a"msg: "b"msg: "c"`
+	if conn.String() != expected {
+		t.Errorf("expected to see '%s' in '%s'", expected, conn.String())
+	}
+	if conn.writes != 1 {
+		t.Errorf("expected the entries to be written at once but got %d writes", conn.writes)
+	}
+	if !conn.closed {
+		t.Error("expected Close to close the connection")
+	}
+}
+
+func TestHookWithBufferedWriterFlushesPeriodically(t *testing.T) {
+	conn := &countingConn{fakeConn: fakeConn{buffer: &bytes.Buffer{}}}
+	h := New(conn, simpleFmter{}, WithBufferedWriter(1024, 5*time.Millisecond))
+	defer h.Close()
+
+	if err := h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for conn.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if conn.String() != `msg: "a"` {
+		t.Errorf("expected the entry to be written by the periodic flush but got '%s'", conn.String())
+	}
+}
+
+func TestHookWithBufferedWriterRecoversFromError(t *testing.T) {
+	conn := &countingConn{fakeConn: fakeConn{buffer: &bytes.Buffer{}, broken: true}}
+	h := New(conn, simpleFmter{}, WithBufferedWriter(1024, 0))
+
+	h.Fire(&logrus.Entry{Message: "lost", Data: logrus.Fields{}})
+	if err := h.Flush(time.Second); err == nil {
+		t.Error("expected Flush to return the write error")
+	}
+
+	conn.broken = false
+	h.Fire(&logrus.Entry{Message: "sent", Data: logrus.Fields{}})
+	if err := h.Flush(time.Second); err != nil {
+		t.Errorf("expected Flush to not return error once the connection works: %s", err)
+	}
+	if conn.String() != `msg: "sent"` {
+		t.Errorf("expected only the entry written after the error to be written but got '%s'", conn.String())
+	}
+}
@@ -0,0 +1,134 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+// WithCompressor compresses the entries with the encoder returned by `fn` for the writer of the hook,
+// e.g. a zstd encoder. See `WithGzip` for the gzip encoder of the standard library.
+// If the encoder has a `Flush() error` method, it is called after every write, i.e. after every entry or,
+// for a hook created by `NewBatchHook`, after every batch, so batching compresses better.
+// `Close` closes the encoder, which writes the end of the compressed stream, and then the writer.
+// The hooks created by `NewHookWithReconnect` and the like start a new compressed stream on every
+// connection they dial, so the receiver can decode the entries sent after a redial.
+func WithCompressor(fn func(io.Writer) io.WriteCloser) HookOption {
+	return func(h *Hook) {
+		h.compress = fn
+	}
+}
+
+const (
+	// FrameRaw is the marker of the frames of `WithCompressionThreshold` whose entry is not compressed.
+	FrameRaw byte = 0
+	// FrameCompressed is the marker of the frames of `WithCompressionThreshold` whose entry is compressed.
+	FrameCompressed byte = 1
+)
+
+// WithCompressionThreshold compresses the entries larger than `threshold` bytes one by one with
+// the encoder returned by `fn`, and sends the smaller entries as they are, since compressing a short
+// entry costs CPU and may even make it larger. Every entry is sent in a frame made of a marker,
+// `FrameCompressed` or `FrameRaw`, the length of the entry as a 4-byte big-endian integer and the entry,
+// compressed or not, so the receiver knows which ones to decompress.
+// The entries are framed (see `WithFraming`) before they are compressed, and the MTU applies to the frames.
+// To compress the entries with gzip:
+//
+// hook := logrustash.New(conn, logrustash.DefaultFormatter(logrus.Fields{}), logrustash.WithCompressionThreshold(1024, func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }))
+//
+// Note: unlike `WithCompressor`, the entries are compressed independently, which needs a receiver that
+// understands the frames, and it should not be combined with `WithCompressor`.
+func WithCompressionThreshold(threshold int, fn func(io.Writer) io.WriteCloser) HookOption {
+	return func(h *Hook) {
+		h.compressThreshold = threshold
+		h.compressEntry = fn
+	}
+}
+
+// compressFrame returns the frame of `WithCompressionThreshold` of the entry `p`.
+func (h *Hook) compressFrame(p []byte) ([]byte, error) {
+	if len(p) <= h.compressThreshold {
+		framed := make([]byte, 5+len(p))
+		framed[0] = FrameRaw
+		binary.BigEndian.PutUint32(framed[1:], uint32(len(p)))
+		copy(framed[5:], p)
+		return framed, nil
+	}
+
+	b := bytes.NewBuffer(make([]byte, 5, 5+len(p)/2))
+	enc := h.compressEntry(b)
+	if _, err := enc.Write(p); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	framed := b.Bytes()
+	framed[0] = FrameCompressed
+	binary.BigEndian.PutUint32(framed[1:], uint32(len(framed)-5))
+	return framed, nil
+}
+
+// compressWriter compresses the data written to it to `w` with `enc`.
+type compressWriter struct {
+	w io.Writer
+
+	mu  sync.Mutex
+	enc io.WriteCloser
+}
+
+// Write compresses `p` and flushes the compressed data to `w`.
+func (c *compressWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n, err := c.enc.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, c.flush()
+}
+
+// Flush flushes the compressed data to `w`.
+func (c *compressWriter) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
+// flush flushes the encoder if it can be flushed.
+func (c *compressWriter) flush() error {
+	if f, ok := c.enc.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address of `w`, if it has one.
+func (c *compressWriter) RemoteAddr() net.Addr {
+	return remoteAddr(c.w)
+}
+
+// Ping checks the connection of `w`, if it has one.
+func (c *compressWriter) Ping() error {
+	return ping(c.w)
+}
+
+// Reconnect dials the connection of `w` again, if it has one.
+func (c *compressWriter) Reconnect() error {
+	return reconnect(c.w)
+}
+
+// Close writes the end of the compressed stream and closes `w` if it implements io.Closer.
+func (c *compressWriter) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.enc.Close()
+	if wc, ok := c.w.(io.Closer); ok {
+		return joinErrors(err, wc.Close())
+	}
+	return err
+}
@@ -0,0 +1,108 @@
+package logrustash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// upperEncoder is an encoder which upper-cases the data and records its flushes.
+type upperEncoder struct {
+	w       io.Writer
+	flushes int
+	closed  bool
+}
+
+func (e *upperEncoder) Write(p []byte) (int, error) {
+	return e.w.Write(bytes.ToUpper(p))
+}
+
+func (e *upperEncoder) Flush() error {
+	e.flushes++
+	return nil
+}
+
+func (e *upperEncoder) Close() error {
+	e.closed = true
+	_, err := e.w.Write([]byte("END"))
+	return err
+}
+
+func TestHookWithCompressor(t *testing.T) {
+	conn := &fakeConn{buffer: &bytes.Buffer{}, addr: fakeAddr("logstash:5000")}
+	var enc *upperEncoder
+	h := New(conn, simpleFmter{}, WithCompressor(func(w io.Writer) io.WriteCloser {
+		enc = &upperEncoder{w: w}
+		return enc
+	}))
+
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if enc.flushes != 2 {
+		t.Errorf("expected the encoder to be flushed after every entry but got %d flushes", enc.flushes)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+
+	if !enc.closed || !conn.closed {
+		t.Errorf("expected Close to close both the encoder and the connection")
+	}
+	expected := `MSG: "A"MSG: "B"END`
+	if conn.buffer.String() != expected {
+		t.Errorf("expected to see '%s' in '%s'", expected, conn.buffer.String())
+	}
+	if addr := h.RemoteAddr(); addr == nil || addr.String() != "logstash:5000" {
+		t.Errorf("expected the remote address of the connection but got %v", addr)
+	}
+}
+
+func TestHookWithCompressionThreshold(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	h := New(buffer, &simpleFmter{}, WithCompressionThreshold(64, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	}))
+
+	large := strings.Repeat("stack trace ", 20)
+	for _, msg := range []string{"short", large} {
+		if err := h.Fire(&logrus.Entry{Message: msg}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+
+	var entries []string
+	var markers []byte
+	for buffer.Len() > 0 {
+		header := buffer.Next(5)
+		p := buffer.Next(int(binary.BigEndian.Uint32(header[1:])))
+		markers = append(markers, header[0])
+		if header[0] == FrameCompressed {
+			gz, err := gzip.NewReader(bytes.NewReader(p))
+			if err != nil {
+				t.Fatalf("expected NewReader to not return error: %s", err)
+			}
+			if p, err = ioutil.ReadAll(gz); err != nil {
+				t.Fatalf("expected ReadAll to not return error: %s", err)
+			}
+		}
+		entries = append(entries, string(p))
+	}
+
+	if !bytes.Equal(markers, []byte{FrameRaw, FrameCompressed}) {
+		t.Errorf("expected the short entry to be raw and the large one to be compressed but got %v", markers)
+	}
+	expected := []string{`msg: "short"`, `msg: "` + large + `"`}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %q but got %q", expected, entries)
+	}
+}
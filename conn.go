@@ -0,0 +1,259 @@
+package logrustash
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultReconnectBackoff is the minimum delay between two dial attempts.
+	defaultReconnectBackoff = time.Second
+	// defaultMTU is the maximum size of a UDP datagram which is not fragmented on most networks.
+	defaultMTU = 1432
+)
+
+// dialFunc dials a connection. It has the signature of `net.Dial`.
+type dialFunc func(network, address string) (net.Conn, error)
+
+// connConfig configures a conn.
+type connConfig struct {
+	// backoff is the minimum delay between two dial attempts.
+	backoff time.Duration
+	// writeTimeout, if set, is the maximum duration of a write. See `WithWriteTimeout`.
+	writeTimeout time.Duration
+	// compress, if set, returns the encoder of every dialed connection. See `WithCompressor`.
+	compress func(io.Writer) io.WriteCloser
+	// onDial, if set, is called when a connection is dialed, with the mutex of the conn held,
+	// so it must neither write nor block. See `WithStartupMarker`.
+	onDial func()
+}
+
+// conn is a connection to Logstash that is redialed when writing to it fails.
+// It is safe for concurrent use: the mutex makes sure only one connection is opened at a time.
+type conn struct {
+	network string
+	address string
+	dial    dialFunc
+	connConfig
+
+	mu sync.Mutex
+	c  net.Conn
+	// w is the writer of the current connection: `c` or, with compression, its encoder.
+	w        io.Writer
+	dialedAt time.Time
+	dialErr  error
+	closed   bool
+}
+
+// dial returns a conn to `address` on `network`.
+// The first connection is dialed immediately and its error is returned.
+func dial(network, address string, d dialFunc, cfg connConfig) (*conn, error) {
+	c := &conn{
+		network:    network,
+		address:    address,
+		dial:       d,
+		connConfig: cfg,
+	}
+	if err := c.redial(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Write writes `p` to the current connection.
+// If the write fails because the connection is broken (see `isConnError`), the connection is closed,
+// redialed and the write is retried once. The other errors are returned and the connection is kept.
+func (c *conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, ErrHookClosed
+	}
+	if c.c != nil {
+		n, err := c.write(p)
+		if err == nil || !isConnError(err) {
+			return n, err
+		}
+		c.closeConn()
+	}
+	if err := c.redial(); err != nil {
+		return 0, err
+	}
+	return c.write(p)
+}
+
+// write writes `p` to the current connection within the write timeout, if any.
+// It must be called with `mu` held.
+func (c *conn) write(p []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.c.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.w.Write(p)
+}
+
+// Ping checks the current connection and redials it if it is broken.
+func (c *conn) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrHookClosed
+	}
+	if c.c != nil {
+		if err := checkConn(c.c); err == nil {
+			return nil
+		}
+		c.closeConn()
+	}
+	return c.redial()
+}
+
+// Reconnect closes the current connection and dials a new one, regardless of the backoff.
+func (c *conn) Reconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return ErrHookClosed
+	}
+	if c.c != nil {
+		c.closeConn()
+	}
+	c.dialErr = nil
+	return c.redial()
+}
+
+// RemoteAddr returns the remote address of the current connection, or nil if there is none.
+func (c *conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.c == nil {
+		return nil
+	}
+	return c.c.RemoteAddr()
+}
+
+// Close closes the current connection. It is not redialed afterwards.
+func (c *conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.c == nil {
+		return nil
+	}
+	return c.closeConn()
+}
+
+// Flush flushes the encoder of the current connection, if any.
+func (c *conn) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f, ok := c.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// closeConn closes the current connection, through its encoder, if any, which writes the end
+// of the compressed stream first. It must be called with `mu` held.
+func (c *conn) closeConn() error {
+	var err error
+	if cw, ok := c.w.(*compressWriter); ok {
+		err = cw.Close()
+	} else {
+		err = c.c.Close()
+	}
+	c.c, c.w = nil, nil
+	return err
+}
+
+// redial dials a new connection unless the previous attempt happened less than `backoff` ago,
+// in which case the error of the previous attempt is returned.
+// It must be called with `mu` held.
+func (c *conn) redial() error {
+	if c.dialErr != nil && time.Since(c.dialedAt) < c.backoff {
+		return c.dialErr
+	}
+	c.dialedAt = time.Now()
+	c.c, c.dialErr = c.dial(c.network, c.address)
+	if c.dialErr != nil {
+		return c.dialErr
+	}
+	c.w = c.c
+	if c.compress != nil {
+		// Every connection gets a new compressed stream, which the receiver can decode from its start.
+		c.w = &compressWriter{w: c.c, enc: c.compress(c.c)}
+	}
+	if c.onDial != nil {
+		c.onDial()
+	}
+	return nil
+}
+
+// isConnError reports whether `err` is returned by a write to a broken connection, which must be redialed:
+// a network error, like a timeout, the end of the connection or a reset connection.
+// The cancellation of a context is not, even though it implements net.Error.
+func isConnError(err error) bool {
+	switch err {
+	case nil, context.Canceled, context.DeadlineExceeded:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF, io.ErrClosedPipe:
+		return true
+	}
+	switch err := err.(type) {
+	case syscall.Errno:
+		// syscall.Errno implements net.Error, so it is checked first.
+		return err == syscall.EPIPE || err == syscall.ECONNRESET
+	case *os.SyscallError:
+		return isConnError(err.Err)
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// timeoutConn is a net.Conn whose writes time out after `timeout`.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(p)
+}
+
+// remoteAddrer is implemented by the writers which send the entries to a remote address,
+// like net.Conn.
+type remoteAddrer interface {
+	RemoteAddr() net.Addr
+}
+
+// remoteAddr returns the remote address of `w`, if it has one.
+func remoteAddr(w io.Writer) net.Addr {
+	if r, ok := w.(remoteAddrer); ok {
+		return r.RemoteAddr()
+	}
+	return nil
+}
+
+// RemoteAddr returns the address of the Logstash instance the hook sends the entries to,
+// i.e. the remote address of its connection, or nil if the hook's writer is not a connection.
+// For the hooks created by `NewFailoverHook` and `NewBalancedHook`, it is the address of
+// the instance the last entry was sent to.
+func (h *Hook) RemoteAddr() net.Addr {
+	return remoteAddr(h.writer)
+}
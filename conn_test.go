@@ -0,0 +1,479 @@
+package logrustash
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type fakeConn struct {
+	net.Conn
+	buffer *bytes.Buffer
+	broken bool
+	// writeErr, if set, is returned by the writes of a broken fakeConn instead of EPIPE.
+	writeErr error
+	closed   bool
+	addr     net.Addr
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// fakeAddr is the address of a fakeConn.
+type fakeAddr string
+
+func (a fakeAddr) Network() string {
+	return "tcp"
+}
+
+func (a fakeAddr) String() string {
+	return string(a)
+}
+
+func (c *fakeConn) Write(p []byte) (int, error) {
+	if c.broken {
+		if c.writeErr != nil {
+			return 0, c.writeErr
+		}
+		return 0, syscall.EPIPE
+	}
+	return c.buffer.Write(p)
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestConnRedialsOnWriteError(t *testing.T) {
+	var conns []*fakeConn
+	d := func(network, address string) (net.Conn, error) {
+		c := &fakeConn{buffer: bytes.NewBuffer(nil)}
+		conns = append(conns, c)
+		return c, nil
+	}
+
+	c, err := dial("tcp", "logstash:9999", d, connConfig{backoff: time.Hour})
+	if err != nil {
+		t.Fatalf("expected dial to not return error: %s", err)
+	}
+	conns[0].broken = true
+
+	if _, err := c.Write([]byte("msg")); err != nil {
+		t.Errorf("expected Write to not return error: %s", err)
+	}
+	if len(conns) != 2 {
+		t.Fatalf("expected the connection to be redialed once but it was dialed %d times", len(conns))
+	}
+	if !conns[0].closed {
+		t.Error("expected the broken connection to be closed")
+	}
+	if conns[1].buffer.String() != "msg" {
+		t.Errorf("expected 'msg' to be written to the new connection but got '%s'", conns[1].buffer.String())
+	}
+}
+
+func TestConnRedialsOnConnErrorsOnly(t *testing.T) {
+	testData := []struct {
+		err    error
+		redial bool
+	}{
+		{io.EOF, true},
+		{syscall.EPIPE, true},
+		{syscall.ECONNRESET, true},
+		{&net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, true},
+		{os.NewSyscallError("write", syscall.EPIPE), true},
+		{timeoutError{}, true},
+		{context.DeadlineExceeded, false},
+		{context.Canceled, false},
+		{syscall.EINVAL, false},
+		{errors.New("failed to format"), false},
+	}
+
+	for _, test := range testData {
+		var conns []*fakeConn
+		d := func(network, address string) (net.Conn, error) {
+			c := &fakeConn{buffer: bytes.NewBuffer(nil)}
+			conns = append(conns, c)
+			return c, nil
+		}
+		c, err := dial("tcp", "logstash:9999", d, connConfig{})
+		if err != nil {
+			t.Fatalf("expected dial to not return error: %s", err)
+		}
+		conns[0].broken = true
+		conns[0].writeErr = test.err
+
+		_, err = c.Write([]byte("msg"))
+		if redialed := len(conns) == 2; redialed != test.redial {
+			t.Errorf("expected the connection to be redialed on %#v: %t but got %t", test.err, test.redial, redialed)
+		}
+		if !test.redial && (err != test.err || conns[0].closed) {
+			t.Errorf("expected %#v to be returned without closing the connection but got %v", test.err, err)
+		}
+	}
+}
+
+// timeoutError is a net.Error which times out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestConnRedialBackoff(t *testing.T) {
+	dials := 0
+	d := func(network, address string) (net.Conn, error) {
+		dials++
+		if dials > 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeConn{buffer: bytes.NewBuffer(nil), broken: true}, nil
+	}
+
+	c, err := dial("tcp", "logstash:9999", d, connConfig{backoff: time.Hour})
+	if err != nil {
+		t.Fatalf("expected dial to not return error: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Write([]byte("msg")); err == nil {
+			t.Error("expected Write to return error")
+		}
+	}
+	if dials != 2 {
+		t.Errorf("expected 2 dials within the backoff but got %d", dials)
+	}
+}
+
+func TestNewHookWithReconnectDialError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	if _, err := NewHookWithReconnect("tcp", address, simpleFmter{}); err == nil {
+		t.Error("expected NewHookWithReconnect to return error")
+	}
+}
+
+// newTLSListener returns a TLS listener on the loopback interface using a self-signed certificate
+// and a client configuration that trusts it.
+func newTLSListener(t *testing.T) (net.Listener, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("expected GenerateKey to not return error: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("expected CreateCertificate to not return error: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("expected ParseCertificate to not return error: %s", err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return l, &tls.Config{RootCAs: pool}
+}
+
+func TestNewHookWithTLS(t *testing.T) {
+	l, clientConfig := newTLSListener(t)
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		received <- line
+	}()
+
+	h, err := NewHookWithTLS(l.Addr().String(), clientConfig, &logrus.JSONFormatter{})
+	if err != nil {
+		t.Fatalf("expected NewHookWithTLS to not return error: %s", err)
+	}
+	if err := h.Fire(&logrus.Entry{Message: "secret", Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error: %s", err)
+	}
+
+	select {
+	case line := <-received:
+		if !strings.Contains(line, `"msg":"secret"`) {
+			t.Errorf("expected to have '%s' in '%s'", `"msg":"secret"`, line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("expected the entry to be received over TLS")
+	}
+}
+
+func TestNewHookWithTLSHandshakeError(t *testing.T) {
+	l, _ := newTLSListener(t)
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		c.(*tls.Conn).Handshake()
+	}()
+
+	// The client does not trust the self-signed certificate.
+	if _, err := NewHookWithTLS(l.Addr().String(), &tls.Config{}, &logrus.JSONFormatter{}); err == nil {
+		t.Error("expected NewHookWithTLS to return the handshake error")
+	}
+}
+
+func TestConnIsNotRedialedAfterClose(t *testing.T) {
+	dials := 0
+	d := func(network, address string) (net.Conn, error) {
+		dials++
+		return &fakeConn{buffer: bytes.NewBuffer(nil)}, nil
+	}
+
+	c, err := dial("tcp", "logstash:9999", d, connConfig{})
+	if err != nil {
+		t.Fatalf("expected dial to not return error: %s", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+	if _, err := c.Write([]byte("msg")); err != ErrHookClosed {
+		t.Errorf("expected Write to return ErrHookClosed but got %v", err)
+	}
+	if dials != 1 {
+		t.Errorf("expected the connection to not be redialed after Close but it was dialed %d times", dials)
+	}
+}
+
+func TestNewHookWithUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected ListenPacket to not return error: %s", err)
+	}
+	defer pc.Close()
+
+	h, err := NewHookWithUDP(pc.LocalAddr().String(), simpleFmter{}, WithMTU(32))
+	if err != nil {
+		t.Fatalf("expected NewHookWithUDP to not return error: %s", err)
+	}
+	defer h.Close()
+
+	if err := h.Fire(&logrus.Entry{Message: "this message does not fit in a datagram", Data: logrus.Fields{}}); err != ErrEntryTooLarge {
+		t.Errorf("expected Fire to return ErrEntryTooLarge but got %v", err)
+	}
+	if err := h.Fire(&logrus.Entry{Message: "hello", Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error: %s", err)
+	}
+
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("expected ReadFrom to not return error: %s", err)
+	}
+	if expected := `msg: "hello"`; string(buf[:n]) != expected {
+		t.Errorf("expected the datagram to be '%s' but got '%s'", expected, string(buf[:n]))
+	}
+	if s := h.Stats(); s.Dropped != 1 || s.Sent != 1 {
+		t.Errorf("expected one sent and one dropped entry but got %+v", s)
+	}
+}
+
+func TestNewHookWithUDPDefaultMTU(t *testing.T) {
+	h, err := NewHookWithUDP("127.0.0.1:8282", simpleFmter{})
+	if err != nil {
+		t.Fatalf("expected NewHookWithUDP to not return error: %s", err)
+	}
+	defer h.Close()
+
+	if h.mtu != 1432 {
+		t.Errorf("expected the default MTU to be 1432 but got %d", h.mtu)
+	}
+}
+
+func TestHookRemoteAddr(t *testing.T) {
+	if addr := New(bytes.NewBuffer(nil), simpleFmter{}).RemoteAddr(); addr != nil {
+		t.Errorf("expected RemoteAddr to return nil for a buffer but got %v", addr)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	defer l.Close()
+
+	h, err := NewHookWithReconnect("tcp", l.Addr().String(), simpleFmter{}, WithGzip(1))
+	if err != nil {
+		t.Fatalf("expected NewHookWithReconnect to not return error: %s", err)
+	}
+	defer h.Close()
+	if addr := h.RemoteAddr(); addr == nil || addr.String() != l.Addr().String() {
+		t.Errorf("expected RemoteAddr to return %s but got %v", l.Addr(), addr)
+	}
+}
+
+func TestMultiEndpointRemoteAddr(t *testing.T) {
+	d := newFakeDialer()
+	fw, err := newFailoverWriter("tcp", []string{"primary", "secondary"}, d.dial, connConfig{})
+	if err != nil {
+		t.Fatalf("expected newFailoverWriter to not return error: %s", err)
+	}
+	if addr := remoteAddr(fw); addr.String() != "primary" {
+		t.Errorf("expected the failover writer to be on 'primary' but got %v", addr)
+	}
+	d.conns["primary"].broken = true
+	d.down["primary"] = true
+	fw.Write([]byte("a"))
+	if addr := remoteAddr(fw); addr.String() != "secondary" {
+		t.Errorf("expected the failover writer to be on 'secondary' but got %v", addr)
+	}
+
+	bw, err := newBalancedWriter("tcp", []string{"a", "b"}, newFakeDialer().dial, connConfig{})
+	if err != nil {
+		t.Fatalf("expected newBalancedWriter to not return error: %s", err)
+	}
+	bw.Write([]byte("1"))
+	bw.Write([]byte("2"))
+	if addr := remoteAddr(bw); addr.String() != "b" {
+		t.Errorf("expected the last entry to be written to 'b' but got %v", addr)
+	}
+}
+
+func TestHookWithWriteTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	h := New(client, simpleFmter{}, WithWriteTimeout(20*time.Millisecond))
+
+	// Nobody reads from the pipe, so the write blocks until it times out.
+	err := h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("expected Fire to return a timeout error but got %v", err)
+	}
+	if h.RemoteAddr() == nil {
+		t.Error("expected RemoteAddr to return the address of the pipe")
+	}
+}
+
+func TestConnWriteTimeoutRedials(t *testing.T) {
+	received := make(chan string, 1)
+	dials := 0
+	d := func(network, address string) (net.Conn, error) {
+		dials++
+		client, server := net.Pipe()
+		if dials > 1 {
+			go func() {
+				line, _ := bufio.NewReader(server).ReadString('\n')
+				received <- line
+			}()
+		}
+		return client, nil
+	}
+
+	c, err := dial("tcp", "logstash:9999", d, connConfig{writeTimeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("expected dial to not return error: %s", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Write([]byte("msg\n")); err != nil {
+		t.Fatalf("expected the write to be retried on a new connection: %s", err)
+	}
+	if dials != 2 {
+		t.Errorf("expected the connection to be redialed once but it was dialed %d times", dials)
+	}
+	if line := <-received; line != "msg\n" {
+		t.Errorf("expected 'msg' to be written to the new connection but got %q", line)
+	}
+}
+
+func TestHookWithKeepAlive(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	defer l.Close()
+
+	h, err := NewHookWithReconnect("tcp", l.Addr().String(), simpleFmter{}, WithKeepAlive(10*time.Second))
+	if err != nil {
+		t.Fatalf("expected NewHookWithReconnect to not return error: %s", err)
+	}
+	defer h.Close()
+	if d := h.dialer(); d.KeepAlive != 10*time.Second {
+		t.Errorf("expected the dialer keep-alive period to be 10s but got %s", d.KeepAlive)
+	}
+	if err := h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error: %s", err)
+	}
+}
+
+func TestHookWithDialTimeout(t *testing.T) {
+	// The listener accepts the connections but never completes the TLS handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	start := time.Now()
+	_, err = NewHookWithTLS(l.Addr().String(), &tls.Config{InsecureSkipVerify: true}, simpleFmter{}, WithDialTimeout(50*time.Millisecond))
+	if err == nil {
+		t.Fatal("expected NewHookWithTLS to return error")
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("expected a timeout error but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the dial to time out quickly but it took %s", elapsed)
+	}
+}
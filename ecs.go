@@ -0,0 +1,87 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ecsVersion is the version of the Elastic Common Schema implemented by ECSFormatter.
+	ecsVersion = "1.6.0"
+	// ecsTimestampFormat is the layout of the "@timestamp" of ECSFormatter, with milliseconds.
+	ecsTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+// ECSFormatter formats an entry to a JSON document following the Elastic Common Schema (ECS).
+//
+// The log time is set to "@timestamp", the message to "message", the level to "log.level" and
+// the caller, if any, to "log.origin". The "error" field, set by logrus' `WithError`, is set to
+// "error.message", "error.type" and, if the error has a stack trace (see `WithStackTrace`),
+// "error.stack_trace". The "log", "error", "service" and "ecs" objects are nested JSON objects.
+// The other fields of the entry data are kept as they are, except that the fields whose key is
+// one of the keys above are prefixed with "fields.".
+type ECSFormatter struct {
+	// ServiceName, if set, is the "service.name" of the documents.
+	ServiceName string
+}
+
+// Format formats an entry to an ECS document.
+func (f ECSFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(e.Data)+6)
+	for k, v := range e.Data {
+		if k == logrus.ErrorKey {
+			continue
+		}
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		switch k {
+		case "@timestamp", "message", "log", "error", "service", "ecs":
+			k = "fields." + k
+		}
+		data[k] = v
+	}
+
+	log := map[string]interface{}{"level": e.Level.String()}
+	if e.Caller != nil {
+		log["origin"] = map[string]interface{}{
+			"file":     map[string]interface{}{"name": e.Caller.File, "line": e.Caller.Line},
+			"function": e.Caller.Function,
+		}
+	}
+	data["log"] = log
+	if v, ok := e.Data[logrus.ErrorKey]; ok {
+		data["error"] = ecsError(v)
+	}
+	if f.ServiceName != "" {
+		data["service"] = map[string]interface{}{"name": f.ServiceName}
+	}
+	data["ecs"] = map[string]interface{}{"version": ecsVersion}
+	data["@timestamp"] = e.Time.Format(ecsTimestampFormat)
+	data["message"] = e.Message
+
+	b := &bytes.Buffer{}
+	if err := json.NewEncoder(b).Encode(data); err != nil {
+		return nil, fmt.Errorf("logrustash: failed to marshal fields to JSON: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// ecsError returns the ECS "error" object of the value `v` of the "error" field.
+func ecsError(v interface{}) map[string]interface{} {
+	err, ok := v.(error)
+	if !ok {
+		return map[string]interface{}{"message": fmt.Sprint(v)}
+	}
+	m := map[string]interface{}{
+		"message": err.Error(),
+		"type":    fmt.Sprintf("%T", err),
+	}
+	if trace, ok := stackTrace(err); ok {
+		m["stack_trace"] = trace
+	}
+	return m
+}
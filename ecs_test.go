@@ -0,0 +1,77 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestECSFormatter(t *testing.T) {
+	formatter := ECSFormatter{ServiceName: "walrus"}
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "request failed",
+		Level:   logrus.ErrorLevel,
+		Time:    time.Date(2017, 5, 3, 10, 20, 30, 123456789, time.UTC),
+		Caller:  &runtime.Frame{File: "main.go", Line: 10, Function: "main.run"},
+		Data: logrus.Fields{
+			logrus.ErrorKey: tracedError{"boom", frames{"main.run\n\tmain.go:10"}},
+			"method":        "GET",
+			"message":       "shadowed",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"@timestamp":     "2017-05-03T10:20:30.123Z",
+		"message":        "request failed",
+		"fields.message": "shadowed",
+		"method":         "GET",
+		"log": map[string]interface{}{
+			"level": "error",
+			"origin": map[string]interface{}{
+				"file":     map[string]interface{}{"name": "main.go", "line": float64(10)},
+				"function": "main.run",
+			},
+		},
+		"error": map[string]interface{}{
+			"message":     "boom",
+			"type":        "logrustash.tracedError",
+			"stack_trace": "main.run\n\tmain.go:10",
+		},
+		"service": map[string]interface{}{"name": "walrus"},
+		"ecs":     map[string]interface{}{"version": ecsVersion},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected %#v but got %#v", expected, m)
+	}
+}
+
+func TestECSFormatterPlainError(t *testing.T) {
+	res, err := ECSFormatter{}.Format(&logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{logrus.ErrorKey: errors.New("plain")}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	expected := map[string]interface{}{"message": "plain", "type": "*errors.errorString"}
+	if !reflect.DeepEqual(m["error"], expected) {
+		t.Errorf("expected the error to be %v but got %v", expected, m["error"])
+	}
+	if _, ok := m["service"]; ok {
+		t.Errorf("expected no service without a service name in '%s'", string(res))
+	}
+}
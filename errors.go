@@ -0,0 +1,97 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithStackTrace adds the stack trace of the errors of the entry data to the entries.
+// An error has a stack trace when it has a `StackTrace()` method, like the errors of
+// github.com/pkg/errors, whose result is formatted with "%+v".
+// The stack trace of the "error" field, set by logrus' `WithError`, is added as the "stacktrace" field
+// and the stack trace of any other field is added as the field's key followed by "_stacktrace".
+// The other fields are not changed.
+func WithStackTrace() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.stackTrace = true
+	}
+}
+
+// stackTraces returns the stack traces of the errors of `data`, keyed as described by `WithStackTrace`.
+func stackTraces(data logrus.Fields) logrus.Fields {
+	var traces logrus.Fields
+	for k, v := range data {
+		err, ok := v.(error)
+		if !ok {
+			continue
+		}
+		trace, ok := stackTrace(err)
+		if !ok {
+			continue
+		}
+		if traces == nil {
+			traces = logrus.Fields{}
+		}
+		if k == logrus.ErrorKey {
+			traces["stacktrace"] = trace
+		} else {
+			traces[k+"_stacktrace"] = trace
+		}
+	}
+	return traces
+}
+
+// stackTrace returns the formatted result of the `StackTrace()` method of `err`, if it has one.
+func stackTrace(err error) (string, bool) {
+	m := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !m.IsValid() || m.Type().NumIn() != 0 || m.Type().NumOut() != 1 {
+		return "", false
+	}
+	trace := m.Call(nil)[0].Interface()
+	return strings.TrimLeft(fmt.Sprintf("%+v", trace), "\n"), true
+}
+
+// StructuredError is implemented by the errors which carry structured data, like a status code.
+// See `WithStructuredErrors`.
+type StructuredError interface {
+	error
+	// ErrorFields returns the data of the error.
+	ErrorFields() map[string]interface{}
+}
+
+// WithStructuredErrors makes the formatter encode the errors of the entry data which implement
+// json.Marshaler with their `MarshalJSON` method, and the errors which implement `StructuredError`
+// as an object of their fields, with a "message" field set to their message unless they have one.
+// The other errors, like the ones usually given to logrus' `WithError`, are still encoded as their message.
+func WithStructuredErrors() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.structuredErrors = true
+	}
+}
+
+// errorValue returns the value to encode for the error `err` of the entry data:
+// its message or, with `WithStructuredErrors`, its structured form.
+func (f LogstashFormatter) errorValue(err error) interface{} {
+	if !f.structuredErrors {
+		return err.Error()
+	}
+	if se, ok := err.(StructuredError); ok {
+		fields := se.ErrorFields()
+		m := make(map[string]interface{}, len(fields)+1)
+		for k, v := range fields {
+			m[k] = v
+		}
+		if _, ok := m["message"]; !ok {
+			m["message"] = err.Error()
+		}
+		return m
+	}
+	if _, ok := err.(json.Marshaler); ok {
+		return err
+	}
+	return err.Error()
+}
@@ -0,0 +1,128 @@
+package logrustash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// frames formats like the stack trace of github.com/pkg/errors.
+type frames []string
+
+func (fr frames) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		for _, frame := range fr {
+			fmt.Fprintf(s, "\n%s", frame)
+		}
+		return
+	}
+	fmt.Fprint(s, []string(fr))
+}
+
+// tracedError is an error with a stack trace.
+type tracedError struct {
+	msg   string
+	stack frames
+}
+
+func (e tracedError) Error() string {
+	return e.msg
+}
+
+func (e tracedError) StackTrace() frames {
+	return e.stack
+}
+
+func TestFormatterWithStackTrace(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithStackTrace())
+
+	res, err := formatter.Format(&logrus.Entry{
+		Data: logrus.Fields{
+			logrus.ErrorKey: tracedError{"boom", frames{"main.run\n\tmain.go:10", "main.main\n\tmain.go:5"}},
+			"cause":         tracedError{"root", frames{"main.init\n\tmain.go:1"}},
+			"plain":         errors.New("plain"),
+			"text":          "not an error",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"error":"boom"`,
+		`"stacktrace":"main.run\n\tmain.go:10\nmain.main\n\tmain.go:5"`,
+		`"cause_stacktrace":"main.init\n\tmain.go:1"`,
+		`"plain":"plain"`,
+		`"text":"not an error"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+	for _, unexp := range []string{"plain_stacktrace", "text_stacktrace"} {
+		if strings.Contains(string(res), unexp) {
+			t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+		}
+	}
+}
+
+// statusError is an error with a status code.
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e statusError) Error() string {
+	return e.msg
+}
+
+func (e statusError) ErrorFields() map[string]interface{} {
+	return map[string]interface{}{"code": e.code}
+}
+
+// jsonError is an error with its own JSON encoding.
+type jsonError struct{}
+
+func (jsonError) Error() string {
+	return "json error"
+}
+
+func (jsonError) MarshalJSON() ([]byte, error) {
+	return []byte(`{"kind":"json"}`), nil
+}
+
+func TestFormatterWithStructuredErrors(t *testing.T) {
+	data := logrus.Fields{
+		logrus.ErrorKey: errors.New("plain"),
+		"status":        statusError{code: 5, msg: "not found"},
+		"custom":        jsonError{},
+	}
+
+	res, err := DefaultFormatter(logrus.Fields{}, WithStructuredErrors()).Format(&logrus.Entry{Data: data})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"error":"plain"`,
+		`"status":{"code":5,"message":"not found"}`,
+		`"custom":{"kind":"json"}`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+
+	res, err = DefaultFormatter(logrus.Fields{}).Format(&logrus.Entry{Data: data})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	for _, exp := range []string{`"status":"not found"`, `"custom":"json error"`} {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package logrustash
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoAddress is returned by the hook constructors which take a list of addresses when it is empty.
+var ErrNoAddress = errors.New("logrustash: no Logstash address")
+
+// NewFailoverHook returns a new logrus.Hook for Logstash that sends the entries over TCP
+// to the first of `addresses` which accepts them.
+// When writing to the current Logstash instance fails, the entry is written to the next one,
+// in order, and the hook keeps writing to the instance which succeeded. `Fire` returns an error
+// only when writing to all the instances failed.
+// A dead instance is redialed, with the backoff of `WithReconnectBackoff`, only when the hook fails over to it again.
+// NewFailoverHook returns an error if none of the instances can be dialed.
+//
+// hook, err := logrustash.NewFailoverHook([]string{"logstash1.corp.io:9999", "logstash2.corp.io:9999"}, logrustash.DefaultFormatter(logrus.Fields{}))
+func NewFailoverHook(addresses []string, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	h := newHook(f, withStreamDefaults(opts)...)
+	w, err := newFailoverWriter("tcp", addresses, h.dialer().Dial, h.connConfig())
+	if err != nil {
+		return nil, err
+	}
+	h.setWriter(h.wrapWriter(w))
+	return h, nil
+}
+
+// dialAll returns a conn to each of `addresses`, dialing them all.
+// The error of the last failed dial is returned if none of them succeeded.
+func dialAll(network string, addresses []string, d dialFunc, cfg connConfig) ([]*conn, error) {
+	if len(addresses) == 0 {
+		return nil, ErrNoAddress
+	}
+	conns := make([]*conn, len(addresses))
+	var lastErr error
+	connected := false
+	for i, address := range addresses {
+		c, err := dial(network, address, d, cfg)
+		if err != nil {
+			// The instance is dialed again on the next write to it.
+			c = &conn{network: network, address: address, dial: d, connConfig: cfg}
+			lastErr = err
+		} else {
+			connected = true
+		}
+		conns[i] = c
+	}
+	if !connected {
+		return nil, lastErr
+	}
+	return conns, nil
+}
+
+// closeAll closes all the connections of `conns`.
+func closeAll(conns []*conn) error {
+	errs := make([]error, len(conns))
+	for i, c := range conns {
+		errs[i] = c.Close()
+	}
+	return joinErrors(errs...)
+}
+
+// failoverWriter writes to the first of its connections which accepts the data,
+// starting from the last one which did.
+type failoverWriter struct {
+	// current is accessed atomically.
+	current int32
+	conns   []*conn
+}
+
+func newFailoverWriter(network string, addresses []string, d dialFunc, cfg connConfig) (*failoverWriter, error) {
+	conns, err := dialAll(network, addresses, d, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &failoverWriter{conns: conns}, nil
+}
+
+// Write writes `p` to the current connection or, if it fails, to the next ones in order.
+// The errors of all the connections are returned if none of them accepted `p`.
+func (w *failoverWriter) Write(p []byte) (int, error) {
+	start := int(atomic.LoadInt32(&w.current))
+	errs := make([]error, 0, len(w.conns))
+	for i := range w.conns {
+		next := (start + i) % len(w.conns)
+		n, err := w.conns[next].Write(p)
+		if err == nil {
+			if next != start {
+				atomic.StoreInt32(&w.current, int32(next))
+			}
+			return n, nil
+		}
+		errs = append(errs, err)
+	}
+	return 0, joinErrors(errs...)
+}
+
+// RemoteAddr returns the remote address of the current connection.
+func (w *failoverWriter) RemoteAddr() net.Addr {
+	return w.conns[atomic.LoadInt32(&w.current)].RemoteAddr()
+}
+
+// Ping checks the connections and returns an error only if none of them is usable.
+func (w *failoverWriter) Ping() error {
+	return pingAny(w.conns)
+}
+
+// Reconnect dials all the connections again.
+func (w *failoverWriter) Reconnect() error {
+	return reconnectConns(w.conns)
+}
+
+// Close closes all the connections.
+func (w *failoverWriter) Close() error {
+	return closeAll(w.conns)
+}
@@ -0,0 +1,94 @@
+package logrustash
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeDialer dials a fakeConn per address. The addresses in `down` refuse the connection.
+type fakeDialer struct {
+	conns map[string]*fakeConn
+	down  map[string]bool
+}
+
+func newFakeDialer(down ...string) *fakeDialer {
+	return &fakeDialer{conns: map[string]*fakeConn{}, down: keySet(down)}
+}
+
+func (d *fakeDialer) dial(network, address string) (net.Conn, error) {
+	if d.down[address] {
+		return nil, errors.New("connection refused")
+	}
+	c := &fakeConn{buffer: bytes.NewBuffer(nil), addr: fakeAddr(address)}
+	d.conns[address] = c
+	return c, nil
+}
+
+func TestFailoverWriter(t *testing.T) {
+	d := newFakeDialer()
+	w, err := newFailoverWriter("tcp", []string{"primary", "secondary"}, d.dial, connConfig{})
+	if err != nil {
+		t.Fatalf("expected newFailoverWriter to not return error: %s", err)
+	}
+
+	w.Write([]byte("a"))
+	d.conns["primary"].broken = true
+	d.down["primary"] = true
+	w.Write([]byte("b"))
+	// The primary is back but the hook keeps writing to the secondary.
+	d.down["primary"] = false
+	w.Write([]byte("c"))
+
+	if d.conns["primary"].buffer.String() != "a" {
+		t.Errorf("expected 'a' to be written to the primary but got '%s'", d.conns["primary"].buffer.String())
+	}
+	if d.conns["secondary"].buffer.String() != "bc" {
+		t.Errorf("expected 'bc' to be written to the secondary but got '%s'", d.conns["secondary"].buffer.String())
+	}
+
+	d.conns["secondary"].broken = true
+	d.down["secondary"] = true
+	if _, err := w.Write([]byte("d")); err != nil {
+		t.Errorf("expected Write to fail over to the primary: %s", err)
+	}
+	if d.conns["primary"].buffer.String() != "d" {
+		t.Errorf("expected 'd' to be written to the redialed primary but got '%s'", d.conns["primary"].buffer.String())
+	}
+
+	d.conns["primary"].broken = true
+	d.down["primary"] = true
+	if _, err := w.Write([]byte("e")); err == nil {
+		t.Error("expected Write to return error when all the instances are down")
+	}
+}
+
+func TestFailoverWriterDialsLazily(t *testing.T) {
+	d := newFakeDialer("primary")
+	w, err := newFailoverWriter("tcp", []string{"primary", "secondary"}, d.dial, connConfig{backoff: time.Hour})
+	if err != nil {
+		t.Fatalf("expected newFailoverWriter to not return error: %s", err)
+	}
+	if _, err := w.Write([]byte("a")); err != nil {
+		t.Errorf("expected Write to not return error: %s", err)
+	}
+	if d.conns["secondary"].buffer.String() != "a" {
+		t.Errorf("expected 'a' to be written to the secondary but got '%s'", d.conns["secondary"].buffer.String())
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+}
+
+func TestNewFailoverHookErrors(t *testing.T) {
+	if _, err := NewFailoverHook(nil, simpleFmter{}); err != ErrNoAddress {
+		t.Errorf("expected NewFailoverHook to return ErrNoAddress but got %v", err)
+	}
+
+	d := newFakeDialer("primary", "secondary")
+	if _, err := newFailoverWriter("tcp", []string{"primary", "secondary"}, d.dial, connConfig{}); err == nil {
+		t.Error("expected newFailoverWriter to return error when no instance can be dialed")
+	}
+}
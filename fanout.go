@@ -0,0 +1,84 @@
+package logrustash
+
+import (
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewFanOutHook returns a new logrus.Hook for Logstash that writes every entry to all of `writers`,
+// e.g. to a Logstash connection and to os.Stdout. The entry is formatted once and written to
+// the writers in parallel, so a slow writer doesn't delay the others, and `Fire` returns when all
+// the writes are done. The errors of the failed writes are combined in the returned error.
+// The options apply to every writer, e.g. `WithGzip` compresses each of them separately.
+func NewFanOutHook(writers []io.Writer, f logrus.Formatter, opts ...HookOption) *Hook {
+	h := newHook(f, opts...)
+	w := &fanOutWriter{writers: make([]io.Writer, len(writers))}
+	for i, ww := range writers {
+		w.writers[i] = h.wrapWriter(ww)
+	}
+	h.setWriter(w)
+	return h
+}
+
+// fanOutWriter writes the data written to it to all of its writers in parallel.
+type fanOutWriter struct {
+	writers []io.Writer
+}
+
+// Write writes `p` to all the writers and returns the errors of the ones which failed.
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	errs := make([]error, len(w.writers))
+	var wg sync.WaitGroup
+	for i, ww := range w.writers {
+		wg.Add(1)
+		go func(i int, ww io.Writer) {
+			defer wg.Done()
+			_, errs[i] = ww.Write(p)
+		}(i, ww)
+	}
+	wg.Wait()
+	if err := joinErrors(errs...); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush flushes the writers which buffer data.
+func (w *fanOutWriter) Flush() error {
+	var errs []error
+	for _, ww := range w.writers {
+		if f, ok := ww.(flusher); ok {
+			errs = append(errs, f.Flush())
+		}
+	}
+	return joinErrors(errs...)
+}
+
+// Ping checks the connections of the writers.
+func (w *fanOutWriter) Ping() error {
+	errs := make([]error, len(w.writers))
+	for i, ww := range w.writers {
+		errs[i] = ping(ww)
+	}
+	return joinErrors(errs...)
+}
+
+// Reconnect dials the connections of the writers which support it again.
+func (w *fanOutWriter) Reconnect() error {
+	return reconnectAll(w.writers)
+}
+
+// Close closes the writers which implement io.Closer and flushes the other ones.
+func (w *fanOutWriter) Close() error {
+	var errs []error
+	for _, ww := range w.writers {
+		if c, ok := ww.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		} else if f, ok := ww.(flusher); ok {
+			errs = append(errs, f.Flush())
+		}
+	}
+	return joinErrors(errs...)
+}
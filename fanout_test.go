@@ -0,0 +1,59 @@
+package logrustash
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFanOutHook(t *testing.T) {
+	formatter := &countingFmter{}
+	first, second := &fakeConn{buffer: &bytes.Buffer{}}, &bytes.Buffer{}
+	h := NewFanOutHook([]io.Writer{first, second}, formatter)
+
+	if err := h.Fire(&logrus.Entry{Message: "hello", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if formatter.count != 1 {
+		t.Errorf("expected the entry to be formatted once but got %d", formatter.count)
+	}
+	if first.buffer.String() != "hello" || second.String() != "hello" {
+		t.Errorf("expected the entry to be written to both writers but got '%s' and '%s'", first.buffer.String(), second.String())
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+	if !first.closed {
+		t.Error("expected Close to close the writers")
+	}
+}
+
+func TestFanOutHookErrorsAndSlowWriter(t *testing.T) {
+	slow := &blockingWriter{release: make(chan struct{})}
+	buffer := &bytes.Buffer{}
+	h := NewFanOutHook([]io.Writer{slow, FailWrite{}, WriterFunc(func(p []byte) (int, error) {
+		// The fast writer is not delayed by the slow one.
+		close(slow.release)
+		return buffer.Write(p)
+	})}, simpleFmter{})
+
+	done := make(chan error)
+	go func() {
+		done <- h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected Fire to return the error of the failed writer but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the writers to be written in parallel")
+	}
+	if buffer.String() != `msg: "a"` || slow.buffer.String() != `msg: "a"` {
+		t.Errorf("expected the entry to be written to the working writers but got '%s' and '%s'", buffer.String(), slow.buffer.String())
+	}
+}
@@ -0,0 +1,491 @@
+package logrustash
+
+import (
+	"bytes"
+	"context"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithNestedKeys splits the keys of the fields on `separator` and nests them into JSON objects,
+// e.g. with "." the field "http.status" is formatted as {"http":{"status":...}}.
+// Formatting an entry returns an error when a key is both a value and an object,
+// e.g. when the entry has both the "http" and "http.status" fields.
+func WithNestedKeys(separator string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.nestSeparator = separator
+	}
+}
+
+// nestedFields is an object built by nestFields from the keys which contain the separator.
+type nestedFields map[string]interface{}
+
+// nestFields returns a copy of `data` whose keys are split on `sep` into nested objects.
+func nestFields(data logrus.Fields, sep string) (logrus.Fields, error) {
+	// The keys are sorted for the reported conflict to be deterministic.
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	nested := make(logrus.Fields, len(data))
+	for _, k := range keys {
+		parts := strings.Split(k, sep)
+		for _, p := range parts {
+			if p == "" {
+				// Keys like ".a" or "a..b" are not split.
+				parts = []string{k}
+				break
+			}
+		}
+
+		obj := map[string]interface{}(nested)
+		for i, p := range parts[:len(parts)-1] {
+			switch v := obj[p].(type) {
+			case nil:
+				child := nestedFields{}
+				obj[p] = child
+				obj = child
+			case nestedFields:
+				obj = v
+			default:
+				return nil, fmt.Errorf("logrustash: field %q conflicts with field %q", k, strings.Join(parts[:i+1], sep))
+			}
+		}
+
+		last := parts[len(parts)-1]
+		if _, ok := obj[last]; ok {
+			return nil, fmt.Errorf("logrustash: field %q conflicts with the fields nested under it", k)
+		}
+		obj[last] = data[k]
+	}
+	return nested, nil
+}
+
+// globalFields are the fields which are added to every entry and can be changed at runtime.
+// It is shared by the copies of a formatter. See `LogstashFormatter.SetGlobalFields`.
+type globalFields struct {
+	mu     sync.Mutex
+	fields atomic.Value
+}
+
+// load returns the current global fields, which must not be changed.
+func (g *globalFields) load() logrus.Fields {
+	if g == nil {
+		return nil
+	}
+	fields, _ := g.fields.Load().(logrus.Fields)
+	return fields
+}
+
+// SetGlobalFields replaces the fields which are added to every entry formatted by `f`,
+// and by its copies, with `fields`. The fields of an entry take precedence over the global fields,
+// which take precedence over `f.Fields`.
+// It is safe to call while entries are formatted.
+//
+// Note: it is a no-op for a formatter which was not created by `NewFormatter`, `DefaultFormatter`
+// or `DefaultFormatterWithKeyMap`.
+func (f LogstashFormatter) SetGlobalFields(fields logrus.Fields) {
+	if f.globals == nil {
+		return
+	}
+	f.globals.mu.Lock()
+	defer f.globals.mu.Unlock()
+
+	f.globals.fields.Store(copyFields(fields))
+}
+
+// AddGlobalField adds the field `key` with `value` to the global fields of `f`. See `SetGlobalFields`.
+func (f LogstashFormatter) AddGlobalField(key string, value interface{}) {
+	if f.globals == nil {
+		return
+	}
+	f.globals.mu.Lock()
+	defer f.globals.mu.Unlock()
+
+	fields := copyFields(f.globals.load())
+	fields[key] = value
+	f.globals.fields.Store(fields)
+}
+
+// copyFields returns a copy of `fields`.
+func copyFields(fields logrus.Fields) logrus.Fields {
+	c := make(logrus.Fields, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
+
+// WithSequence adds a sequence number to every formatted entry as the field `key`: 1 for the first
+// entry, 2 for the second one, and so on, so a gap in the sequence numbers received by Logstash
+// shows that entries were lost, e.g. with `NewHookWithUDP`.
+// The counter is incremented atomically and is shared by the copies of the formatter,
+// so it keeps counting across reconnects. An entry which already has the field `key` keeps its value.
+func WithSequence(key string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.sequenceKey = key
+		f.sequence = new(uint64)
+	}
+}
+
+// WithMaxDepth limits the depth of the objects and arrays of the fields of the entries to `n`,
+// e.g. so the documents don't exceed the depth limit of an Elasticsearch index: the objects and
+// arrays deeper than `n` levels in a field are replaced by a string, their JSON encoding, so their data
+// is kept. The value of a field, like {"a":{"b":1}}, is at depth 1, its "a" object at depth 2, and so on.
+// It applies after the fields are nested by `WithNestedKeys`.
+func WithMaxDepth(n int) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.maxDepth = n
+	}
+}
+
+// limitDepths limits the depth of the fields of `data` as described by `WithMaxDepth`.
+func (f LogstashFormatter) limitDepths(data logrus.Fields) {
+	for k, v := range data {
+		switch v.(type) {
+		case nil, string, bool, int, int64, float64, error, json.Number, json.RawMessage:
+			continue
+		}
+		if opaqueStruct(v) {
+			// It is formatted as a string when the entry is encoded (see `opaqueStruct`), so it has no depth.
+			continue
+		}
+		// The value is encoded and decoded so the structs and the maps of any type are limited too.
+		p, err := f.marshal(v)
+		if err != nil {
+			// It is reported when the entry is encoded.
+			continue
+		}
+		d := json.NewDecoder(bytes.NewReader(p))
+		d.UseNumber()
+		var decoded interface{}
+		if err := d.Decode(&decoded); err != nil {
+			continue
+		}
+		switch decoded.(type) {
+		case map[string]interface{}, []interface{}:
+			data[k] = f.limitDepth(decoded, 1)
+		}
+	}
+}
+
+// limitDepth returns `v`, decoded from JSON at depth `depth`, with the objects and arrays deeper
+// than the maximum depth replaced by their JSON encoding.
+func (f LogstashFormatter) limitDepth(v interface{}, depth int) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if depth > f.maxDepth {
+			return encodeDecoded(v)
+		}
+		for k, e := range v {
+			v[k] = f.limitDepth(e, depth+1)
+		}
+	case []interface{}:
+		if depth > f.maxDepth {
+			return encodeDecoded(v)
+		}
+		for i, e := range v {
+			v[i] = f.limitDepth(e, depth+1)
+		}
+	}
+	return v
+}
+
+// encodeDecoded returns the JSON encoding of `v`, decoded from JSON, which can always be encoded.
+func encodeDecoded(v interface{}) string {
+	p, _ := json.Marshal(v)
+	return string(p)
+}
+
+// WithGoroutineID adds the ID of the goroutine which formats an entry as the "goroutine_id" field,
+// e.g. to follow the entries of one goroutine while debugging a deadlock. The entries are formatted
+// by `Fire`, so it is the goroutine which logged the entry, for asynchronous hooks too.
+//
+// Note: the ID is parsed from the stack trace of the goroutine, which is slow and may not work
+// with future versions of Go, in which case the field is omitted. It is meant for debugging only.
+func WithGoroutineID() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.goroutineID = true
+	}
+}
+
+// goroutineID returns the ID of the current goroutine, parsed from the header of its stack trace,
+// e.g. "goroutine 18 [running]:", or 0 if it can't be parsed.
+func goroutineID() uint64 {
+	var b [64]byte
+	s := strings.TrimPrefix(string(b[:runtime.Stack(b[:], false)]), "goroutine ")
+	if i := strings.IndexByte(s, ' '); i > 0 {
+		s = s[:i]
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
+// WithTags adds `tags` to the "tags" field of every entry, the array of tags which Logstash filters
+// match on. The tags of an entry which already has a "tags" field, a string or a slice, are kept:
+// the tags are merged without duplicates, the tags of the entry first.
+func WithTags(tags ...string) FormatterOption {
+	tags = append([]string(nil), tags...)
+	return func(f *LogstashFormatter) {
+		f.tags = tags
+	}
+}
+
+// mergeTags sets the "tags" field of `data` to its tags followed by `tags`, without duplicates.
+func mergeTags(data logrus.Fields, tags []string) {
+	var merged []string
+	switch v := data["tags"].(type) {
+	case nil:
+	case string:
+		merged = append(merged, v)
+	case []string:
+		merged = append(merged, v...)
+	default:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			merged = append(merged, fmt.Sprint(v))
+			break
+		}
+		for i := 0; i < rv.Len(); i++ {
+			merged = append(merged, fmt.Sprint(rv.Index(i).Interface()))
+		}
+	}
+	seen := make(map[string]bool, len(merged)+len(tags))
+	unique := make([]string, 0, len(merged)+len(tags))
+	for _, t := range append(merged, tags...) {
+		if !seen[t] {
+			seen[t] = true
+			unique = append(unique, t)
+		}
+	}
+	data["tags"] = unique
+}
+
+// WithFieldPrefix prepends `prefix` to the keys of the fields of the formatted entries, e.g. "app_"
+// so the fields of an application don't conflict with the fields of other applications in the same index.
+// The "@version" and "type" keys of the formatter are not prefixed, while the fields of the entries
+// named like the other Logstash keys, e.g. "level", are, so they don't clash with them.
+// The prefix is added to the keys after they are renamed by `FormatterConfig.KeyMap` and flattened,
+// and before they are nested by `WithNestedKeys`.
+func WithFieldPrefix(prefix string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.fieldPrefix = prefix
+	}
+}
+
+// prefixFields returns a copy of `data` with its keys prefixed as described by `WithFieldPrefix`.
+func (f LogstashFormatter) prefixFields(data logrus.Fields) logrus.Fields {
+	// The time, message and level keys are added when the entry is encoded, so the fields with their
+	// keys are data of the entry itself.
+	reserved := []string{renameKey("@version", f.KeyMap), renameKey("type", f.KeyMap)}
+	prefixed := make(logrus.Fields, len(data))
+	for k, v := range data {
+		if !containsString(reserved, k) {
+			k = f.fieldPrefix + k
+		}
+		prefixed[k] = v
+	}
+	return prefixed
+}
+
+// WithRawFormatter adds the output of `rf` for every entry, without its trailing newline,
+// as the string field `key`, e.g. the line of a logrus.TextFormatter for grep.
+// `rf` formats the entry as it was logged, before the fields and options of the formatter are applied.
+// If `rf` fails, the entry is formatted without the field and the error is reported to the handler
+// set by `OnFormatError`. An entry is never formatted by `rf` again while it is formatted by `rf`,
+// so `rf` may embed the formatter itself.
+func WithRawFormatter(key string, rf logrus.Formatter) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.rawKey = key
+		f.rawFormatter = rf
+	}
+}
+
+// rawFormatting is the key of the context value of the entries which are formatted by a raw formatter.
+type rawFormatting struct{}
+
+// rawOutput returns the output of the formatter's raw formatter for the entry `e` and whether
+// it was formatted. See `WithRawFormatter`.
+func (f LogstashFormatter) rawOutput(e *logrus.Entry) (string, bool) {
+	if e.Context != nil && e.Context.Value(rawFormatting{}) != nil {
+		return "", false
+	}
+	re := copyEntry(e)
+	defer releaseEntry(re)
+	ctx := e.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	re.Context = context.WithValue(ctx, rawFormatting{}, true)
+	re.Buffer = nil
+
+	p, err := f.rawFormatter.Format(re)
+	if err != nil {
+		f.reportError(e, fmt.Errorf("logrustash: raw formatter failed: %v", err))
+		return "", false
+	}
+	return strings.TrimRight(string(p), "\r\n"), true
+}
+
+// OmitEmpty removes the fields whose value is nil, an empty string or an empty slice or map
+// from the formatted entries. Other zero values, like 0 or false, are kept, and so are
+// the Logstash fields ("@timestamp", "message", "level", "@version" and "type").
+func OmitEmpty() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.omitEmpty = true
+	}
+}
+
+// omitEmptyFields removes the fields of `data` which are empty as described by `OmitEmpty`.
+func omitEmptyFields(data logrus.Fields) {
+	for k, v := range data {
+		if k == "@version" || k == "type" {
+			continue
+		}
+		if isEmpty(v) {
+			delete(data, k)
+		}
+	}
+}
+
+// isEmpty reports whether `v` is nil, an empty string or an empty slice or map.
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	}
+	return false
+}
+
+// WithFlatten flattens the fields whose values are maps into top-level fields
+// whose keys are joined with `separator`, e.g. with "." the field "req" with the value
+// map[string]interface{}{"method": "GET"} is formatted as the field "req.method".
+// Nested maps are flattened recursively, slices are kept as they are and nil values are skipped.
+// A field of the entry which already has the key of a flattened field takes precedence over it.
+func WithFlatten(separator string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.flattenSeparator = separator
+	}
+}
+
+// WithFlattenStructs makes `WithFlatten` flatten the exported fields of struct values as well.
+// The key of a struct field is its JSON name. Structs which implement json.Marshaler
+// or encoding.TextMarshaler, like time.Time, are not flattened.
+func WithFlattenStructs() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.flattenStructs = true
+	}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// flattenFields returns a copy of `data` whose map values, and struct values if `structs` is true,
+// are flattened into fields whose keys are joined with `sep`.
+func flattenFields(data logrus.Fields, sep string, structs bool) logrus.Fields {
+	flat := make(logrus.Fields, len(data))
+	var nested []string
+	for k, v := range data {
+		if flattenable(reflect.ValueOf(v), structs) {
+			nested = append(nested, k)
+			continue
+		}
+		flat[k] = v
+	}
+	for _, k := range nested {
+		flattenValue(flat, k, reflect.ValueOf(data[k]), sep, structs)
+	}
+	return flat
+}
+
+// flattenValue adds the value `v` to `flat` under `key`, flattening it if it is a map or a struct.
+// Nil values are skipped and the keys which are already in `flat` are kept.
+func flattenValue(flat logrus.Fields, key string, v reflect.Value, sep string, structs bool) {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Ptr && !flattenable(v, structs) {
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return
+	}
+
+	switch {
+	case v.Kind() == reflect.Map && flattenable(v, structs):
+		for _, mk := range v.MapKeys() {
+			flattenValue(flat, key+sep+mk.String(), v.MapIndex(mk), sep, structs)
+		}
+	case v.Kind() == reflect.Struct && flattenable(v, structs):
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				continue
+			}
+			name := sf.Name
+			if tag := strings.Split(sf.Tag.Get("json"), ",")[0]; tag == "-" {
+				continue
+			} else if tag != "" {
+				name = tag
+			}
+			flattenValue(flat, key+sep+name, v.Field(i), sep, structs)
+		}
+	default:
+		if _, ok := flat[key]; !ok {
+			flat[key] = v.Interface()
+		}
+	}
+}
+
+// flattenable reports whether `v` is a map with string keys or, if `structs` is true,
+// a struct which doesn't marshal itself.
+func flattenable(v reflect.Value, structs bool) bool {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return false
+		}
+		if v.Kind() == reflect.Ptr && (v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType)) {
+			return false
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		return v.Type().Key().Kind() == reflect.String
+	case reflect.Struct:
+		if !structs {
+			return false
+		}
+		pt := reflect.PtrTo(v.Type())
+		return !pt.Implements(jsonMarshalerType) && !pt.Implements(textMarshalerType)
+	}
+	return false
+}
@@ -0,0 +1,464 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFormatterWithNestedKeys(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithNestedKeys("."))
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "msg1",
+		Data: logrus.Fields{
+			"http.status":     200,
+			"http.method":     "GET",
+			"http.request.id": "abc",
+			"user":            "walrus",
+			".hidden":         true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+
+	expected := []string{
+		`"http":{"method":"GET","request":{"id":"abc"},"status":200}`,
+		`"user":"walrus"`,
+		`".hidden":true`,
+		`"message":"msg1"`,
+		`"@version":"1"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+}
+
+func TestFormatterWithNestedKeysConflict(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithNestedKeys("."))
+
+	testData := []logrus.Fields{
+		{"http": "bla", "http.status": 200},
+		{"http.status": 200, "http.status.code": 200},
+	}
+	for _, data := range testData {
+		if _, err := formatter.Format(&logrus.Entry{Data: data}); err == nil {
+			t.Errorf("expected Format to return error for conflicting fields %v", data)
+		}
+	}
+}
+
+func TestFormatterWithoutNestedKeys(t *testing.T) {
+	res, err := DefaultFormatter(logrus.Fields{}).Format(&logrus.Entry{Data: logrus.Fields{"http.status": 200}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if exp := `"http.status":200`; !strings.Contains(string(res), exp) {
+		t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+	}
+}
+
+func TestFormatterWithFlatten(t *testing.T) {
+	type user struct {
+		Name    string `json:"name"`
+		Email   string `json:"-"`
+		Admin   bool
+		private int
+	}
+
+	testData := []struct {
+		formatter  logrus.Formatter
+		expected   []string
+		unexpected []string
+	}{
+		{
+			DefaultFormatter(logrus.Fields{}, WithFlatten(".")),
+			[]string{
+				`"req.method":"GET"`,
+				`"req.headers.accept":"*/*"`,
+				`"req.ids":[1,2]`,
+				`"req.path":"/explicit"`,
+				`"user":{"name":"walrus","Admin":true}`,
+				`"started":"2017-05-03T10:20:30Z"`,
+			},
+			[]string{`"req.body"`, `"req":`},
+		},
+		{
+			DefaultFormatter(logrus.Fields{}, WithFlatten("_"), WithFlattenStructs()),
+			[]string{
+				`"req_method":"GET"`,
+				`"user_name":"walrus"`,
+				`"user_Admin":true`,
+				`"started":"2017-05-03T10:20:30Z"`,
+			},
+			[]string{`"user_Email"`, `"user_private"`, `"started_`},
+		},
+	}
+
+	for _, test := range testData {
+		res, err := test.formatter.Format(&logrus.Entry{
+			Data: logrus.Fields{
+				"req": map[string]interface{}{
+					"method":  "GET",
+					"headers": map[string]string{"accept": "*/*"},
+					"ids":     []int{1, 2},
+					"body":    nil,
+					"path":    "/",
+				},
+				"req.path": "/explicit",
+				"user":     &user{Name: "walrus", Email: "walrus@corp.io", Admin: true},
+				"started":  time.Date(2017, 5, 3, 10, 20, 30, 0, time.UTC),
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		for _, exp := range test.expected {
+			if !strings.Contains(string(res), exp) {
+				t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+			}
+		}
+		for _, unexp := range test.unexpected {
+			if strings.Contains(string(res), unexp) {
+				t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+			}
+		}
+	}
+}
+
+func TestFormatterGlobalFields(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{"service": "api", "env": "dev"}).(LogstashFormatter)
+	formatter.SetGlobalFields(logrus.Fields{"env": "prod"})
+	// The copies of the formatter share the global fields.
+	copied := formatter
+	copied.AddGlobalField("version", "1.2.3")
+
+	res, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{"version": "override"}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	for _, exp := range []string{`"service":"api"`, `"env":"prod"`, `"version":"override"`} {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+
+	formatter.SetGlobalFields(nil)
+	res, err = formatter.Format(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !strings.Contains(string(res), `"env":"dev"`) || strings.Contains(string(res), `"version":`) {
+		t.Errorf("expected the global fields to be removed from '%s'", string(res))
+	}
+}
+
+func TestFormatterGlobalFieldsConcurrently(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}).(LogstashFormatter)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				formatter.AddGlobalField(fmt.Sprint("k", i), j)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if _, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{}}); err != nil {
+					t.Errorf("expected Format to not return error: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestFormatterWithSequence(t *testing.T) {
+	buffer := &CaptureBuffer{}
+	h := New(buffer, DefaultFormatter(logrus.Fields{}, WithSequence("seq")))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if err := h.Fire(&logrus.Entry{Data: logrus.Fields{}}); err != nil {
+					t.Errorf("expected Fire to not return error: %s", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries := buffer.Entries()
+	if len(entries) != 100 {
+		t.Fatalf("expected 100 entries but got %d", len(entries))
+	}
+	seen := map[float64]bool{}
+	for _, e := range entries {
+		seen[e["seq"].(float64)] = true
+	}
+	for i := 1; i <= 100; i++ {
+		if !seen[float64(i)] {
+			t.Errorf("expected an entry with the sequence number %d", i)
+		}
+	}
+}
+
+func TestFormatterOmitEmpty(t *testing.T) {
+	var nilPointer *time.Time
+	formatter := NewFormatter(FormatterConfig{Fields: logrus.Fields{"env": ""}}, OmitEmpty())
+
+	res, err := formatter.Format(&logrus.Entry{
+		Data: logrus.Fields{
+			"nil":         nil,
+			"nil_pointer": nilPointer,
+			"empty":       "",
+			"slice":       []string{},
+			"map":         map[string]interface{}{},
+			"zero":        0,
+			"false":       false,
+			"user":        "walrus",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	for _, exp := range []string{`"zero":0`, `"false":false`, `"user":"walrus"`, `"message":""`, `"@timestamp":`, `"type":"log"`} {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+	for _, unexp := range []string{`"nil"`, `"nil_pointer"`, `"empty"`, `"slice"`, `"map"`, `"env"`} {
+		if strings.Contains(string(res), unexp) {
+			t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+		}
+	}
+}
+
+func TestFormatterWithFieldPrefix(t *testing.T) {
+	keyMap := map[string]string{"user": "account"}
+	formatter := DefaultFormatterWithKeyMap(logrus.Fields{}, keyMap, WithFieldPrefix("app_"), WithFlatten("."), WithNestedKeys("/"))
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "hello",
+		Data: logrus.Fields{
+			"id":     1,
+			"user":   "bob",
+			"http":   map[string]interface{}{"status": 200},
+			"nest/a": "b",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"app_id":1`,
+		`"app_account":"bob"`,
+		`"app_http.status":200`,
+		`"app_nest":{"a":"b"}`,
+		`"@version":"1"`,
+		`"type":"log"`,
+		`"message":"hello"`,
+		`"level":"panic"`,
+		`"@timestamp":`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+}
+
+func TestFormatterWithFieldPrefixReservedKeys(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithFieldPrefix("app_"))
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "hello",
+		Level:   logrus.InfoLevel,
+		Data:    logrus.Fields{"level": "debug", "message": "user"},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"app_level":"debug"`,
+		`"app_message":"user"`,
+		`"level":"info"`,
+		`"message":"hello"`,
+		`"@version":"1"`,
+		`"type":"log"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+}
+
+// selfFormatter formats the entries with the formatter it points to.
+type selfFormatter struct {
+	f *logrus.Formatter
+}
+
+func (s selfFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	return (*s.f).Format(e)
+}
+
+func TestFormatterWithRawFormatter(t *testing.T) {
+	text := &logrus.TextFormatter{DisableTimestamp: true, DisableColors: true}
+	formatter := DefaultFormatter(logrus.Fields{}, WithRawFormatter("raw", text), WithFieldFilter([]string{"secret"}))
+
+	res, err := formatter.Format(&logrus.Entry{Message: "hello", Level: logrus.InfoLevel, Data: logrus.Fields{"id": 1, "secret": "s"}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := `"raw":"level=info msg=hello id=1 secret=s`
+	if !strings.Contains(string(res), expected) {
+		t.Errorf("expected to have '%s' in '%s'", expected, string(res))
+	}
+}
+
+func TestFormatterWithRecursiveRawFormatter(t *testing.T) {
+	var formatter logrus.Formatter
+	formatter = DefaultFormatter(logrus.Fields{}, WithRawFormatter("raw", selfFormatter{&formatter}))
+
+	res, err := formatter.Format(&logrus.Entry{Message: "hello", Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	raw, _ := m["raw"].(string)
+	if !strings.Contains(raw, `"message":"hello"`) || strings.Contains(raw, `raw`) {
+		t.Errorf("expected the raw field to be the entry formatted once but got %q", raw)
+	}
+}
+
+func TestFormatterWithGoroutineID(t *testing.T) {
+	res, err := DefaultFormatter(logrus.Fields{}, WithGoroutineID()).Format(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	id, ok := m["goroutine_id"].(float64)
+	if !ok || id <= 0 || id != float64(int64(id)) {
+		t.Errorf("expected goroutine_id to be a positive integer but got %#v", m["goroutine_id"])
+	}
+
+	res, err = DefaultFormatter(logrus.Fields{}).Format(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if strings.Contains(string(res), "goroutine_id") {
+		t.Errorf("expected no goroutine_id by default in '%s'", string(res))
+	}
+}
+
+func TestFormatterWithTags(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithTags("app", "billing"))
+
+	testData := []struct {
+		data     logrus.Fields
+		expected []interface{}
+	}{
+		{logrus.Fields{}, []interface{}{"app", "billing"}},
+		{logrus.Fields{"tags": "audit"}, []interface{}{"audit", "app", "billing"}},
+		{logrus.Fields{"tags": []string{"billing", "audit"}}, []interface{}{"billing", "audit", "app"}},
+		{logrus.Fields{"tags": []interface{}{"audit", 1}}, []interface{}{"audit", "1", "app", "billing"}},
+	}
+
+	for _, test := range testData {
+		res, err := formatter.Format(&logrus.Entry{Data: test.data})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(res, &m); err != nil {
+			t.Fatalf("expected Unmarshal to not return error: %s", err)
+		}
+		if !reflect.DeepEqual(m["tags"], test.expected) {
+			t.Errorf("expected the tags of %v to be %v but got %v", test.data, test.expected, m["tags"])
+		}
+	}
+}
+
+func TestFormatterWithMaxDepth(t *testing.T) {
+	// deep is {"level":1,"next":{"level":2,"next":...}} with 10 levels.
+	var deep interface{} = map[string]interface{}{"level": 10}
+	for i := 9; i >= 1; i-- {
+		deep = map[string]interface{}{"level": i, "next": deep}
+	}
+	formatter := DefaultFormatter(logrus.Fields{}, WithMaxDepth(3))
+
+	res, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{
+		"deep":   deep,
+		"list":   []interface{}{[]interface{}{[]interface{}{[]int{1}}}},
+		"status": 200,
+	}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+
+	third := m["deep"].(map[string]interface{})["next"].(map[string]interface{})["next"].(map[string]interface{})
+	if third["level"] != float64(3) {
+		t.Errorf("expected the 3 first levels to be objects but got %v", m["deep"])
+	}
+	fourth, ok := third["next"].(string)
+	if !ok {
+		t.Fatalf("expected the fourth level to be a string but got %#v", third["next"])
+	}
+	var rest interface{}
+	if err := json.Unmarshal([]byte(fourth), &rest); err != nil {
+		t.Fatalf("expected the fourth level to be JSON but got %s", err)
+	}
+	if !strings.Contains(fourth, `"level":10`) || rest.(map[string]interface{})["level"] != float64(4) {
+		t.Errorf("expected the fourth level to keep the deeper levels but got %s", fourth)
+	}
+
+	if !strings.Contains(string(res), `"list":[[["[1]"]]]`) || m["status"] != float64(200) {
+		t.Errorf("expected the arrays to be limited and the other fields to be kept in '%s'", string(res))
+	}
+}
+
+func TestFormatterWithMaxDepthOpaqueStruct(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithMaxDepth(1))
+
+	res, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{
+		"user": opaque{42, "walrus"},
+		"ptr":  &opaque{7, "seal"},
+	}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	if m["user"] != "{id:42 name:walrus}" || m["ptr"] != "&{id:7 name:seal}" {
+		t.Errorf("expected the structs without exported fields to be formatted with %%+v but got %#v and %#v", m["user"], m["ptr"])
+	}
+}
@@ -0,0 +1,107 @@
+package logrustash
+
+import (
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewFileHook returns a new logrus.Hook for Logstash that writes the entries to the file `path`,
+// e.g. for Filebeat to ship them. Every entry is terminated by a newline unless set differently
+// by `WithFraming`, and the entries are appended to the file if it exists.
+// When writing an entry would make the file larger than `maxBytes`, the file is renamed
+// to `path` followed by ".1", replacing the previous one, and a new file is created.
+// A `maxBytes` of 0 disables the rotation.
+//
+// hook, err := logrustash.NewFileHook("/var/log/app/logstash.json", 100<<20, logrustash.DefaultFormatter(logrus.Fields{}))
+func NewFileHook(path string, maxBytes int64, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	h := newHook(f, withStreamDefaults(opts)...)
+	w, err := newFileWriter(path, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	h.setWriter(h.wrapWriter(w))
+	return h, nil
+}
+
+// fileWriter appends the data written to it to a file which it rotates by size.
+// The mutex makes sure an entry is never written while the file is rotated.
+type fileWriter struct {
+	path     string
+	maxBytes int64
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	closed bool
+}
+
+func newFileWriter(path string, maxBytes int64) (*fileWriter, error) {
+	w := &fileWriter{path: path, maxBytes: maxBytes}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends `p` to the file, after rotating it if `p` does not fit in it.
+func (w *fileWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, ErrHookClosed
+	}
+	if w.f != nil && w.maxBytes > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxBytes {
+		// If the file can't be renamed, the entries are still appended to it.
+		w.rotate()
+	}
+	if w.f == nil {
+		// The file failed to be reopened by the previous rotation.
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file. Writing to it afterwards fails with `ErrHookClosed`.
+func (w *fileWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// open opens the file for appending. It must be called with `mu` held, or before the writer is used.
+func (w *fileWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate renames the file and opens a new one. It must be called with `mu` held.
+func (w *fileWriter) rotate() error {
+	w.f.Close()
+	w.f = nil
+	err := os.Rename(w.path, w.path+".1")
+	return joinErrors(err, w.open())
+}
@@ -0,0 +1,89 @@
+package logrustash
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFileHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logrustash")
+	if err != nil {
+		t.Fatalf("expected TempDir to not return error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logstash.json")
+
+	// Every entry is 9 bytes with its newline, so the file is rotated after 10 entries.
+	h, err := NewFileHook(path, 90, simpleFmter{})
+	if err != nil {
+		t.Fatalf("expected NewFileHook to not return error: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if err := h.Fire(&logrus.Entry{Message: fmt.Sprint(i), Data: logrus.Fields{}}); err != nil {
+					t.Errorf("expected Fire to not return error: %s", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+
+	rotated, err := ioutil.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatalf("expected the file to be rotated: %s", err)
+	}
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected ReadFile to not return error: %s", err)
+	}
+	if len(rotated) != 90 || len(current) != 45 {
+		t.Errorf("expected 90 bytes in the rotated file and 45 in the current one but got %d and %d", len(rotated), len(current))
+	}
+	for _, data := range [][]byte{rotated, current} {
+		for _, line := range bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) {
+			if len(line) != 8 {
+				t.Errorf("expected every line to be an entry but got '%s'", line)
+			}
+		}
+	}
+
+	if err := h.Fire(&logrus.Entry{Message: "late", Data: logrus.Fields{}}); err != ErrHookClosed {
+		t.Errorf("expected Fire to return ErrHookClosed after Close but got %v", err)
+	}
+}
+
+func TestFileHookAppends(t *testing.T) {
+	f, err := ioutil.TempFile("", "logrustash")
+	if err != nil {
+		t.Fatalf("expected TempFile to not return error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("existing\n")
+	f.Close()
+
+	h, err := NewFileHook(f.Name(), 0, simpleFmter{})
+	if err != nil {
+		t.Fatalf("expected NewFileHook to not return error: %s", err)
+	}
+	h.Fire(&logrus.Entry{Message: "new", Data: logrus.Fields{}})
+	h.Close()
+
+	data, _ := ioutil.ReadFile(f.Name())
+	if string(data) != "existing\nmsg: \"new\"\n" {
+		t.Errorf("expected the entry to be appended but got '%s'", string(data))
+	}
+}
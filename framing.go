@@ -0,0 +1,54 @@
+package logrustash
+
+import (
+	"encoding/binary"
+)
+
+// Framing is the way the formatted entries are delimited in the stream sent to Logstash.
+type Framing int
+
+const (
+	// FramingNone writes the entries as they are formatted. The Logstash formatters
+	// terminate every entry with a newline, for the `json_lines` codec. It is the default of `New`.
+	FramingNone Framing = iota
+	// FramingLengthPrefix writes the length of every entry as a 4-byte big-endian integer
+	// before the entry, so entries may contain newlines.
+	FramingLengthPrefix
+	// FramingNewline terminates every entry with a newline, unless it already ends with one,
+	// for the `json_lines` and `line` codecs. It is the default of the hooks which send
+	// the entries over a TCP connection, like `NewHookWithReconnect`.
+	FramingNewline
+)
+
+// WithFraming sets the way the formatted entries are delimited. See `Framing`.
+func WithFraming(f Framing) HookOption {
+	return func(h *Hook) {
+		h.framing = f
+	}
+}
+
+// frame returns the formatted entry `p` delimited according to the hook's framing.
+func (h *Hook) frame(p []byte) []byte {
+	switch h.framing {
+	case FramingLengthPrefix:
+		framed := make([]byte, 4+len(p))
+		binary.BigEndian.PutUint32(framed, uint32(len(p)))
+		copy(framed[4:], p)
+		return framed
+	case FramingNewline:
+		if len(p) > 0 && p[len(p)-1] == '\n' {
+			return p
+		}
+		framed := make([]byte, len(p)+1)
+		copy(framed, p)
+		framed[len(p)] = '\n'
+		return framed
+	}
+	return p
+}
+
+// withStreamDefaults returns `opts` preceded by the defaults of the hooks which send
+// the entries over a stream connection, so `opts` can override them.
+func withStreamDefaults(opts []HookOption) []HookOption {
+	return append([]HookOption{WithFraming(FramingNewline)}, opts...)
+}
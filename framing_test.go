@@ -0,0 +1,132 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// readFrames decodes a stream of length-prefixed messages.
+func readFrames(t *testing.T, r io.Reader) []string {
+	var messages []string
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err == io.EOF {
+			return messages
+		} else if err != nil {
+			t.Fatalf("expected to read the length of a message: %s", err)
+		}
+		p := make([]byte, size)
+		if _, err := io.ReadFull(r, p); err != nil {
+			t.Fatalf("expected to read a message of %d bytes: %s", size, err)
+		}
+		messages = append(messages, string(p))
+	}
+}
+
+func TestHookWithLengthPrefixFraming(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := New(buffer, simpleFmter{}, WithFraming(FramingLengthPrefix))
+
+	for _, msg := range []string{"first\nline", "second"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+
+	messages := readFrames(t, buffer)
+	if len(messages) != 2 || messages[0] != `msg: "first\nline"` || messages[1] != `msg: "second"` {
+		t.Errorf("expected to decode the two messages but got %#v", messages)
+	}
+}
+
+func TestBatchHookWithLengthPrefixFraming(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := NewBatchHook(buffer, DefaultFormatter(logrus.Fields{}), 1024, time.Hour, WithFraming(FramingLengthPrefix))
+
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+
+	messages := readFrames(t, buffer)
+	if len(messages) != 2 {
+		t.Fatalf("expected to decode two messages but got %#v", messages)
+	}
+	for _, message := range messages {
+		if !bytes.HasSuffix([]byte(message), []byte("}\n")) {
+			t.Errorf("expected the message to be the formatted entry but got '%s'", message)
+		}
+	}
+}
+
+func TestHookWithNewlineFraming(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := New(buffer, simpleFmter{}, WithFraming(FramingNewline))
+	if err := h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if buffer.String() != "msg: \"a\"\n" {
+		t.Errorf("expected the entry to be terminated by a newline but got %q", buffer.String())
+	}
+
+	buffer.Reset()
+	h = New(buffer, DefaultFormatter(logrus.Fields{}), WithFraming(FramingNewline))
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines but got %q", buffer.String())
+	}
+	for _, line := range lines {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Errorf("expected '%s' to be a JSON message: %s", line, err)
+		}
+	}
+}
+
+func TestNewHookWithReconnectTerminatesEntries(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	defer l.Close()
+
+	h, err := NewHookWithReconnect("tcp", l.Addr().String(), simpleFmter{})
+	if err != nil {
+		t.Fatalf("expected NewHookWithReconnect to not return error: %s", err)
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf("expected Accept to not return error: %s", err)
+	}
+	defer c.Close()
+
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	h.Close()
+
+	p, _ := ioutil.ReadAll(c)
+	if string(p) != "msg: \"a\"\nmsg: \"b\"\n" {
+		t.Errorf("expected the entries to be terminated by newlines but got %q", string(p))
+	}
+}
@@ -2,6 +2,7 @@ package logrustash_test
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -151,3 +152,58 @@ func TestUDPWritter(t *testing.T) {
 
 	log.Info("this is an information message")
 }
+
+func TestFormatterWithCaller(t *testing.T) {
+	log := logrus.New()
+	log.Out = bytes.NewBufferString("")
+	buffer := bytes.NewBufferString("")
+	log.Hooks.Add(logrustash.New(buffer, logrustash.DefaultFormatter(logrus.Fields{}, logrustash.WithCaller())))
+
+	log.Info("without caller")
+	for _, unexp := range []string{"caller_file", "caller_line", "caller_func"} {
+		if strings.Contains(buffer.String(), unexp) {
+			t.Errorf("expected to not have '%s' in '%s'", unexp, buffer.String())
+		}
+	}
+
+	buffer.Reset()
+	log.SetReportCaller(true)
+	log.Info("with caller")
+	expected := []string{
+		`"caller_file":"`,
+		`functional_test.go"`,
+		`"caller_line":`,
+		`"caller_func":"github.com/bshuster-repo/logrus-logstash-hook_test.TestFormatterWithCaller"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(buffer.String(), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, buffer.String())
+		}
+	}
+}
+
+type requestIDKey struct{}
+
+func TestFormatterWithContextExtractor(t *testing.T) {
+	log := logrus.New()
+	log.Out = bytes.NewBufferString("")
+	buffer := bytes.NewBufferString("")
+	extractor := func(ctx context.Context) logrus.Fields {
+		return logrus.Fields{"request_id": ctx.Value(requestIDKey{}), "trace_id": "t-1"}
+	}
+	log.Hooks.Add(logrustash.New(buffer, logrustash.DefaultFormatter(logrus.Fields{}, logrustash.WithContextExtractor(extractor))))
+
+	log.Info("without context")
+	if strings.Contains(buffer.String(), "trace_id") {
+		t.Errorf("expected to not have 'trace_id' in '%s'", buffer.String())
+	}
+
+	buffer.Reset()
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "r-1")
+	log.WithContext(ctx).WithField("trace_id", "t-2").Info("with context")
+	for _, exp := range []string{`"request_id":"r-1"`, `"trace_id":"t-2"`} {
+		if !strings.Contains(buffer.String(), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, buffer.String())
+		}
+	}
+}
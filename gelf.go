@@ -0,0 +1,79 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gelfVersion is the version of the GELF specification implemented by GELFFormatter.
+const gelfVersion = "1.1"
+
+// GELFFormatter formats an entry to a Graylog Extended Log Format (GELF) message,
+// so the hook can send the entries to Graylog instead of Logstash.
+//
+// The entry message is set to "short_message" (its first line) and "full_message" (when it has several lines),
+// the log time to "timestamp" as seconds since the Unix epoch and the log level to "level" as a syslog severity.
+// The fields of the entry data are additional fields, so their keys are prefixed with an underscore.
+// The "id" field, which GELF reserves, is sent as "__id".
+type GELFFormatter struct {
+	// Host is the "host" of the messages. It defaults to the machine hostname.
+	Host string
+}
+
+// Format formats an entry to a GELF message.
+func (f GELFFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	host := f.Host
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+
+	data := make(logrus.Fields, len(e.Data)+6)
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		if k == "id" {
+			k = "_id"
+		}
+		data["_"+k] = v
+	}
+
+	data["version"] = gelfVersion
+	data["host"] = host
+	data["timestamp"] = float64(e.Time.UnixNano()) / 1e9
+	data["level"] = syslogSeverity(e.Level)
+	data["short_message"] = e.Message
+	if i := strings.IndexByte(e.Message, '\n'); i >= 0 {
+		data["short_message"] = e.Message[:i]
+		data["full_message"] = e.Message
+	}
+
+	b := &bytes.Buffer{}
+	if err := json.NewEncoder(b).Encode(data); err != nil {
+		return nil, fmt.Errorf("logrustash: failed to marshal fields to JSON: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// syslogSeverity returns the syslog severity of a logrus level.
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 1 // alert
+	case logrus.FatalLevel:
+		return 2 // critical
+	case logrus.ErrorLevel:
+		return 3 // error
+	case logrus.WarnLevel:
+		return 4 // warning
+	case logrus.InfoLevel:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
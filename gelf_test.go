@@ -0,0 +1,94 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestGELFFormatter(t *testing.T) {
+	formatter := GELFFormatter{Host: "web1"}
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "request failed\nstack trace",
+		Level:   logrus.ErrorLevel,
+		Time:    time.Unix(1493806830, 500000000),
+		Data: logrus.Fields{
+			"method":  "GET",
+			"host":    "example.com",
+			"version": 2,
+			"id":      42,
+			"error":   errors.New("timeout"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	expected := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "web1",
+		"short_message": "request failed",
+		"full_message":  "request failed\nstack trace",
+		"timestamp":     1493806830.5,
+		"level":         float64(3),
+		"_method":       "GET",
+		"_host":         "example.com",
+		"_version":      float64(2),
+		"__id":          float64(42),
+		"_error":        "timeout",
+	}
+	if len(m) != len(expected) {
+		t.Errorf("expected %d keys but got %#v", len(expected), m)
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("expected %s to be %#v but got %#v", k, v, m[k])
+		}
+	}
+}
+
+func TestGELFFormatterSingleLineMessage(t *testing.T) {
+	res, err := GELFFormatter{Host: "web1"}.Format(&logrus.Entry{Message: "hello", Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	if m["short_message"] != "hello" {
+		t.Errorf("expected short_message to be 'hello' but got %#v", m["short_message"])
+	}
+	if _, ok := m["full_message"]; ok {
+		t.Errorf("expected to not have full_message in '%s'", string(res))
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	testData := []struct {
+		level    logrus.Level
+		expected int
+	}{
+		{logrus.PanicLevel, 1},
+		{logrus.FatalLevel, 2},
+		{logrus.ErrorLevel, 3},
+		{logrus.WarnLevel, 4},
+		{logrus.InfoLevel, 6},
+		{logrus.DebugLevel, 7},
+	}
+
+	for _, test := range testData {
+		if s := syslogSeverity(test.level); s != test.expected {
+			t.Errorf("expected the severity of %s to be %d but got %d", test.level, test.expected, s)
+		}
+	}
+}
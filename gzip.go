@@ -0,0 +1,23 @@
+package logrustash
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// WithGzip compresses the entries with gzip at `level`, one of the compress/gzip levels,
+// e.g. for a Logstash TCP input with the `gzip_lines` codec. An invalid level is replaced by
+// `gzip.DefaultCompression`.
+// The compressed stream is flushed after every write, i.e. after every entry or, for
+// a hook created by `NewBatchHook`, after every batch, so batching compresses better.
+// `Close` writes the end of the gzip stream. The hooks created by `NewHookWithReconnect` and the like
+// start a new gzip stream on every connection they dial.
+func WithGzip(level int) HookOption {
+	return WithCompressor(func(w io.Writer) io.WriteCloser {
+		gz, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			gz = gzip.NewWriter(w)
+		}
+		return gz
+	})
+}
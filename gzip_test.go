@@ -0,0 +1,114 @@
+package logrustash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHookWithGzip(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := New(buffer, simpleFmter{}, WithGzip(gzip.BestSpeed))
+
+	if err := h.Fire(&logrus.Entry{Message: "first", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	// The entry is flushed so it can be decompressed before the stream ends.
+	r, err := gzip.NewReader(bytes.NewReader(buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("expected the written data to be gzipped: %s", err)
+	}
+	expected := `msg: "first"`
+	p := make([]byte, len(expected))
+	if _, err := io.ReadFull(r, p); err != nil || string(p) != expected {
+		t.Errorf("expected to read '%s' before Close but got '%s' (%v)", expected, string(p), err)
+	}
+
+	if err := h.Fire(&logrus.Entry{Message: "second", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+	checkGzipped(t, buffer.Bytes(), `msg: "first"msg: "second"`)
+}
+
+func TestBatchHookWithGzip(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := NewBatchHook(buffer, simpleFmter{}, 1024, time.Hour, WithGzip(gzip.DefaultCompression))
+
+	for _, msg := range []string{"aaa", "bbb"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("expected nothing to be written before the batch is full but got %d bytes", buffer.Len())
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+	checkGzipped(t, buffer.Bytes(), "msg: \"aaa\"\nmsg: \"bbb\"\n")
+}
+
+// checkGzipped checks that `data` is a complete gzip stream of `expected`.
+func checkGzipped(t *testing.T, data []byte, expected string) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected the written data to be gzipped: %s", err)
+	}
+	res, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("expected the gzip stream to be complete: %s", err)
+	}
+	if string(res) != expected {
+		t.Errorf("expected to decompress '%s' but got '%s'", expected, string(res))
+	}
+}
+
+func TestConnWithGzipRedial(t *testing.T) {
+	var conns []*fakeConn
+	d := func(network, address string) (net.Conn, error) {
+		c := &fakeConn{buffer: bytes.NewBuffer(nil)}
+		conns = append(conns, c)
+		return c, nil
+	}
+	h := New(nil, simpleFmter{}, WithGzip(gzip.BestSpeed))
+	c, err := dial("tcp", "logstash:9999", d, h.connConfig())
+	if err != nil {
+		t.Fatalf("expected dial to not return error: %s", err)
+	}
+	h.writer = h.wrapWriter(c)
+
+	if err := h.Fire(&logrus.Entry{Message: "first", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	conns[0].broken = true
+	if err := h.Fire(&logrus.Entry{Message: "second", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+	if len(conns) != 2 {
+		t.Fatalf("expected the connection to be redialed once but it was dialed %d times", len(conns))
+	}
+
+	// The stream of the broken connection is flushed but never ended.
+	r, err := gzip.NewReader(bytes.NewReader(conns[0].buffer.Bytes()))
+	if err != nil {
+		t.Fatalf("expected the data of the first connection to be gzipped: %s", err)
+	}
+	expected := `msg: "first"`
+	p := make([]byte, len(expected))
+	if _, err := io.ReadFull(r, p); err != nil || string(p) != expected {
+		t.Errorf("expected to read '%s' from the first connection but got '%s' (%v)", expected, string(p), err)
+	}
+	checkGzipped(t, conns[1].buffer.Bytes(), `msg: "second"`)
+}
@@ -1,8 +1,18 @@
 package logrustash
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -12,11 +22,174 @@ import (
 // formatter to format the entry to a Logstash format before sending.
 //
 // To initialize it use the `New` function.
-//
 type Hook struct {
+	// stats is accessed atomically and must stay the first field to be 64-bit aligned.
+	stats hookStats
+
 	writer    io.Writer
 	formatter logrus.Formatter
-	levels    []logrus.Level
+	// levelWriters, if set, are the writers of the entries of their level. See `NewHookWithLevelWriters`.
+	levelWriters map[logrus.Level]io.Writer
+	// sinksMu guards sinks, the writers registered by `RegisterWriter`, which is replaced and never changed in place.
+	sinksMu sync.RWMutex
+	sinks   map[string]io.Writer
+	sinkKey string
+	// writeMu serializes the writes, so the entries fired concurrently are never interleaved.
+	writeMu sync.Mutex
+	// levelsMu guards levels, which is replaced and never changed in place.
+	levelsMu sync.RWMutex
+	levels   []logrus.Level
+
+	reconnectBackoff time.Duration
+	writeTimeout     time.Duration
+	keepAlive        time.Duration
+	dialTimeout      time.Duration
+	maxAttempts      int
+	retryDelay       time.Duration
+	onError          func(*logrus.Entry, error)
+	mtu              int
+	framing          Framing
+	limiter          *rateLimiter
+	summarize        bool
+	budget           *byteBudget
+	marker           *startupMarker
+	samplers         map[logrus.Level]*sampler
+	fallback         io.Writer
+	noShipKey        string
+	// compress, if set, returns the encoder of the writer of the hook. See `WithCompressor`.
+	compress func(io.Writer) io.WriteCloser
+	// compressThreshold and compressEntry, if set, compress the large entries. See `WithCompressionThreshold`.
+	compressThreshold int
+	compressEntry     func(io.Writer) io.WriteCloser
+	// bufferSize and bufferInterval configure the buffering of the writes. See `WithBufferedWriter`.
+	bufferSize     int
+	bufferInterval time.Duration
+	// httpClient and httpHeader configure the requests of the hooks created by `NewHTTPHook`.
+	httpClient *http.Client
+	httpHeader http.Header
+
+	// queue, overflowPolicy, overflowHandler, overflowTimeout, canceled, done, closeMu, closed, pendingMu,
+	// pending and idle are only used by asynchronous hooks. See `NewAsyncHook`. canceled is accessed atomically.
+	queue           chan queuedEntry
+	overflowPolicy  OverflowPolicy
+	overflowHandler OverflowHandler
+	overflowTimeout time.Duration
+	canceled        int32
+	done            chan struct{}
+	closeMu         sync.RWMutex
+	closed          bool
+	pendingMu       sync.Mutex
+	pending         int
+	idle            chan struct{}
+}
+
+// HookOption configures an optional behavior of a Hook.
+// Options are passed to `New` or to any of the other hook constructors.
+type HookOption func(*Hook)
+
+// WithReconnectBackoff sets the minimum delay between two dial attempts
+// of a hook created by `NewHookWithReconnect`.
+// It prevents a flapping Logstash instance from causing a tight reconnect loop.
+func WithReconnectBackoff(d time.Duration) HookOption {
+	return func(h *Hook) {
+		h.reconnectBackoff = d
+	}
+}
+
+// WithWriteTimeout sets the maximum duration of a write to a network connection,
+// so a half-open connection doesn't block `Fire` forever.
+// A write which times out fails like any other write: the connection of the hooks
+// created by `NewHookWithReconnect` and the like is redialed and the write is retried.
+func WithWriteTimeout(d time.Duration) HookOption {
+	return func(h *Hook) {
+		h.writeTimeout = d
+	}
+}
+
+// WithKeepAlive enables TCP keep-alive with a period of `d` on the connections dialed by
+// the hooks created by `NewHookWithReconnect` and the like, so the idle connections
+// which are dropped by a firewall are detected before the next entry is sent.
+// A negative period disables keep-alive. It has no effect on UDP connections.
+func WithKeepAlive(d time.Duration) HookOption {
+	return func(h *Hook) {
+		h.keepAlive = d
+	}
+}
+
+// WithDialTimeout sets the maximum duration of a dial of the hooks created by `NewHookWithReconnect`
+// and the like, so a hook fails fast when Logstash is unreachable, e.g. at startup. It applies to the
+// first dial, whose error is returned by the constructor, and to the redials. For a TLS connection,
+// it includes the TLS handshake. There is no timeout by default but the one of the operating system.
+func WithDialTimeout(d time.Duration) HookOption {
+	return func(h *Hook) {
+		h.dialTimeout = d
+	}
+}
+
+// dialer returns the dialer of the connections dialed by the hook.
+func (h *Hook) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: h.dialTimeout, KeepAlive: h.keepAlive}
+}
+
+// connConfig returns the configuration of the connections dialed by the hook.
+func (h *Hook) connConfig() connConfig {
+	return connConfig{
+		backoff:      h.reconnectBackoff,
+		writeTimeout: h.writeTimeout,
+		compress:     h.compress,
+		onDial:       h.dialed,
+	}
+}
+
+// maxRetryDelay caps the delay between two attempts to write an entry.
+const maxRetryDelay = 30 * time.Second
+
+// WithRetry makes the hook retry a failed write up to `maxAttempts` attempts in total.
+// The delay between two attempts starts at `baseDelay` and doubles after every attempt, up to 30 seconds.
+// A synchronous hook retries in `Fire`, so the caller waits for the retries, while
+// an asynchronous hook retries in its worker goroutine.
+func WithRetry(maxAttempts int, baseDelay time.Duration) HookOption {
+	return func(h *Hook) {
+		h.maxAttempts = maxAttempts
+		h.retryDelay = baseDelay
+	}
+}
+
+// WithFallbackWriter sets the writer of the entries which the hook fails to write, e.g. `os.Stderr`
+// so the entries are not lost while Logstash is unreachable. An entry is written to `w` once all
+// the attempts to write it failed (see `WithRetry`); the error of the write is still returned by `Fire`,
+// or passed to the error handler of an asynchronous hook, and counted in `Stats().Failed`.
+// The errors of `w` are ignored.
+func WithFallbackWriter(w io.Writer) HookOption {
+	return func(h *Hook) {
+		h.fallback = w
+	}
+}
+
+// WithMTU sets the maximum size of a formatted entry. Larger entries are dropped:
+// `Fire` returns `ErrEntryTooLarge` and the entry is counted in `Stats().Dropped`.
+// It defaults to 1432 bytes for the hooks created by `NewHookWithUDP` and is unlimited otherwise.
+func WithMTU(n int) HookOption {
+	return func(h *Hook) {
+		h.mtu = n
+	}
+}
+
+// OnError registers `fn` to be called when writing an entry fails in the background,
+// i.e. in the worker goroutine of an asynchronous hook or when a batch is written periodically,
+// where the error can't be returned by `Fire`. `e` is nil when the failed write was a whole batch.
+// `fn` is never called while the hook holds a lock so it is safe for it to log.
+func OnError(fn func(e *logrus.Entry, err error)) HookOption {
+	return func(h *Hook) {
+		h.onError = fn
+	}
+}
+
+// reportError calls the hook's error handler, if any.
+func (h *Hook) reportError(e *logrus.Entry, err error) {
+	if h.onError != nil {
+		h.onError(e, err)
+	}
 }
 
 // New returns a new logrus.Hook for Logstash.
@@ -25,36 +198,332 @@ type Hook struct {
 //
 // conn, _ := net.Dial("tcp", "logstash.corp.io:9999")
 // hook := logrustash.New(conn, logrustash.DefaultFormatter())
-func New(w io.Writer, f logrus.Formatter) Hook {
-	return Hook{
-		writer:    w,
-		formatter: f,
-		levels:    logrus.AllLevels,
+//
+// If `w` is nil, `Fire` returns `ErrNoWriter` instead of writing the entries.
+func New(w io.Writer, f logrus.Formatter, opts ...HookOption) *Hook {
+	h := newHook(f, opts...)
+	h.setWriter(h.wrapWriter(w))
+	return h
+}
+
+// newHook returns a new hook without a writer, configured by `opts`.
+// The constructors set its writer with `setWriter`.
+func newHook(f logrus.Formatter, opts ...HookOption) *Hook {
+	h := &Hook{
+		formatter:        f,
+		levels:           logrus.AllLevels,
+		reconnectBackoff: defaultReconnectBackoff,
+		noShipKey:        NoShipKey,
+		sinkKey:          SinkKey,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// setWriter sets the writer of the hook to `w`, which is already wrapped, and sends the startup marker,
+// if any, now that the hook is created, unless the hook has no writer for it. See `WithStartupMarker`.
+func (h *Hook) setWriter(w io.Writer) {
+	h.writer = w
+	if h.marker == nil {
+		return
+	}
+	if _, err := h.writerFor(logrus.InfoLevel, ""); err == nil {
+		atomic.StoreInt32(&h.marker.due, 1)
+		h.sendStartupMarker(h.queue != nil)
+	}
+}
+
+// WriterFunc is an adapter to use a function as the writer of a hook, e.g. to publish the entries
+// to a message bus:
+//
+// hook := logrustash.New(logrustash.WriterFunc(func(p []byte) (int, error) { return len(p), bus.Publish(p) }), logrustash.DefaultFormatter(logrus.Fields{}))
+//
+// The function is given every formatted entry, which it must not retain since `p` may be reused.
+type WriterFunc func(p []byte) (int, error)
+
+// Write calls `fn(p)`.
+func (fn WriterFunc) Write(p []byte) (int, error) {
+	return fn(p)
+}
+
+// wrapWriter returns `w` wrapped according to the hook's options: with a write timeout
+// if it is a net.Conn, compressed and buffered.
+func (h *Hook) wrapWriter(w io.Writer) io.Writer {
+	if w == nil {
+		return nil
 	}
+	if c, ok := w.(net.Conn); ok && h.writeTimeout > 0 {
+		w = &timeoutConn{Conn: c, timeout: h.writeTimeout}
+	}
+	if h.compress != nil {
+		switch w.(type) {
+		case *conn, *failoverWriter, *balancedWriter:
+			// Their connections are compressed one by one. See `connConfig`.
+		default:
+			w = &compressWriter{w: w, enc: h.compress(w)}
+		}
+	}
+	if h.bufferSize > 0 {
+		w = newBufferedWriter(w, h.bufferSize, h.bufferInterval, func(err error) {
+			h.reportError(nil, err)
+		})
+	}
+	return w
+}
+
+// NewHookWithReconnect returns a new logrus.Hook for Logstash that dials `address` on `network`.
+// Unlike `New`, the hook keeps the dial parameters and when writing an entry fails,
+// it redials the connection and retries the write once before returning the error.
+// Every entry is terminated by a newline unless set differently by `WithFraming`.
+//
+// hook, err := logrustash.NewHookWithReconnect("tcp", "logstash.corp.io:9999", logrustash.DefaultFormatter(logrus.Fields{}))
+func NewHookWithReconnect(network, address string, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	h := newHook(f, withStreamDefaults(opts)...)
+	c, err := dial(network, address, h.dialer().Dial, h.connConfig())
+	if err != nil {
+		return nil, err
+	}
+	h.setWriter(h.wrapWriter(c))
+	return h, nil
+}
+
+// NewHookWithUDP returns a new logrus.Hook for Logstash that sends the entries to `address` over UDP.
+// Every entry is sent in a single datagram, so entries which are larger than the MTU
+// (1432 bytes unless set by `WithMTU`) are dropped instead of being fragmented.
+//
+// hook, err := logrustash.NewHookWithUDP("logstash.corp.io:9999", logrustash.DefaultFormatter(logrus.Fields{}))
+func NewHookWithUDP(address string, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	h := newHook(f, opts...)
+	if h.mtu == 0 {
+		h.mtu = defaultMTU
+	}
+	c, err := dial("udp", address, h.dialer().Dial, h.connConfig())
+	if err != nil {
+		return nil, err
+	}
+	h.setWriter(h.wrapWriter(c))
+	return h, nil
+}
+
+// NewHookWithTLS returns a new logrus.Hook for Logstash that sends the entries to `address`
+// over a TLS connection configured by `tlsConfig`.
+// The TLS handshake happens in NewHookWithTLS, so its error is returned here and not on the first `Fire`.
+// Like `NewHookWithReconnect`, the connection is redialed when writing to it fails.
+//
+// hook, err := logrustash.NewHookWithTLS("logstash.corp.io:9999", &tls.Config{}, logrustash.DefaultFormatter(logrus.Fields{}))
+func NewHookWithTLS(address string, tlsConfig *tls.Config, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	h := newHook(f, withStreamDefaults(opts)...)
+	d := func(network, address string) (net.Conn, error) {
+		return tls.DialWithDialer(h.dialer(), network, address, tlsConfig)
+	}
+	c, err := dial("tcp", address, d, h.connConfig())
+	if err != nil {
+		return nil, err
+	}
+	h.setWriter(h.wrapWriter(c))
+	return h, nil
 }
 
 // Fire takes, formats and sends the entry to Logstash.
 // Hook's formatter is used to format the entry into Logstash format
 // and Hook's writer is used to write the formatted entry to the Logstash instance.
-func (h Hook) Fire(e *logrus.Entry) error {
-	// Skip firing of event if log level is to high
-	if len(h.levels) > 0 && h.levels[len(h.levels)-1] < e.Level {
+// If the formatter returns an error, it is returned and nothing is written,
+// even if the formatter returned some bytes with the error.
+// Fire is safe for concurrent use: each entry is written at once, never interleaved with another.
+func (h *Hook) Fire(e *logrus.Entry) error {
+	// Skip firing of event if log level is not enabled, before the entry is formatted.
+	if !h.IsLevelEnabled(e.Level) {
 		return nil
 	}
+	if h.noShipKey != "" {
+		if v, ok := e.Data[h.noShipKey]; ok {
+			if v == true {
+				return nil
+			}
+			e = withoutField(e, h.noShipKey)
+		}
+	}
+	sink := ""
+	if h.sinkKey != "" {
+		if v, ok := e.Data[h.sinkKey]; ok {
+			sink = fmt.Sprint(v)
+			e = withoutField(e, h.sinkKey)
+		}
+	}
+	if s := h.samplers[e.Level]; s != nil && !s.sample() {
+		atomic.AddUint64(&h.stats.dropped, 1)
+		return nil
+	}
+	return h.send(e, sink, true)
+}
 
-	dataBytes, err := h.formatter.Format(e)
+// send formats, frames and writes or queues the entry `e` to the writer registered as `sink`,
+// or to the writer of its level if `sink` is empty. The entry is limited by the byte budget and,
+// if `limited`, by the rate limit of the hook.
+func (h *Hook) send(e *logrus.Entry, sink string, limited bool) error {
+	w, err := h.writerFor(e.Level, sink)
+	if err != nil {
+		return err
+	}
+	dataBytes, err := h.encode(e)
 	if err != nil {
 		return err
 	}
-	_, err = h.writer.Write(dataBytes)
+	if h.budget != nil || (limited && h.limiter != nil) {
+		allowed, suppressed := h.admit(time.Now(), len(dataBytes), limited)
+		if !allowed {
+			atomic.AddUint64(&h.stats.dropped, 1)
+			return nil
+		}
+		if suppressed > 0 && h.summarize && h.IsLevelEnabled(logrus.WarnLevel) {
+			if err := h.send(suppressedEntry(e, suppressed), "", false); err != nil {
+				return err
+			}
+		}
+	}
+	if h.queue != nil {
+		if e.Buffer != nil {
+			// The formatter may have written the entry to its buffer, which logrus re-uses.
+			dataBytes = append([]byte(nil), dataBytes...)
+		}
+		return h.enqueue(e, w, dataBytes, false)
+	}
+	err = h.write(w, dataBytes)
+	// The write may have redialed the connection.
+	h.sendStartupMarker(false)
 	return err
 }
 
-// Levels returns all logrus levels.
-func (h Hook) Levels() []logrus.Level {
+// encode formats and frames the entry `e`, compresses it as set by `WithCompressionThreshold`,
+// and checks that it fits in the MTU.
+func (h *Hook) encode(e *logrus.Entry) ([]byte, error) {
+	if h.formatter == nil {
+		return nil, ErrNoFormatter
+	}
+	p, err := h.formatter.Format(e)
+	if err != nil {
+		// The bytes returned with an error may be a partial entry, so they are never written.
+		return nil, err
+	}
+	p = h.frame(p)
+	if h.compressEntry != nil {
+		if p, err = h.compressFrame(p); err != nil {
+			return nil, err
+		}
+	}
+	if h.mtu > 0 && len(p) > h.mtu {
+		atomic.AddUint64(&h.stats.dropped, 1)
+		return nil, ErrEntryTooLarge
+	}
+	return p, nil
+}
+
+// write writes `p` to `w` and retries as configured by `WithRetry`.
+// It returns the error of the last attempt.
+func (h *Hook) write(w io.Writer, p []byte) error {
+	for attempt := 1; ; attempt++ {
+		h.writeMu.Lock()
+		_, err := w.Write(p)
+		h.writeMu.Unlock()
+		if err == nil || attempt >= h.maxAttempts {
+			// The batch writer counts its entries once the batch is written.
+			if _, ok := w.(*batchWriter); !ok {
+				h.stats.written(1, err)
+			}
+			if err != nil && h.fallback != nil {
+				h.writeMu.Lock()
+				h.fallback.Write(p)
+				h.writeMu.Unlock()
+			}
+			return err
+		}
+		atomic.AddUint64(&h.stats.retried, 1)
+		time.Sleep(retryDelay(h.retryDelay, attempt))
+	}
+}
+
+// retryDelay returns the delay before the attempt which follows attempt number `attempt`:
+// `base` doubled for every previous attempt and capped by `maxRetryDelay`.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 1; i < attempt && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	if d > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return d
+}
+
+// Close stops the hook and closes its writer.
+// For an asynchronous hook, the entries which are already queued are written and then
+// the worker goroutine stops. Entries fired after Close are rejected with `ErrHookClosed`.
+// For a hook created by `NewBatchHook`, the last partial batch is written.
+// Finally, if the writer implements io.Closer (e.g. a net.Conn), it is closed.
+// Every writer of a hook created by `NewHookWithLevelWriters` is closed.
+//
+// The returned error covers both writing the pending entries and closing the writers.
+func (h *Hook) Close() error {
+	if h.queue != nil {
+		h.stopQueue()
+		<-h.done
+	}
+
+	var errs []error
+	for _, w := range h.writers() {
+		if c, ok := w.(io.Closer); ok {
+			errs = append(errs, c.Close())
+		} else if f, ok := w.(flusher); ok {
+			errs = append(errs, f.Flush())
+		}
+	}
+	return joinErrors(errs...)
+}
+
+// Levels returns a copy of the levels of the hook, all logrus levels unless set differently.
+// Changing the returned slice doesn't change the hook.
+func (h *Hook) Levels() []logrus.Level {
+	return append([]logrus.Level(nil), h.enabledLevels()...)
+}
+
+// enabledLevels returns the levels of the hook without copying them, so it must not be changed.
+func (h *Hook) enabledLevels() []logrus.Level {
+	h.levelsMu.RLock()
+	defer h.levelsMu.RUnlock()
+
 	return h.levels
 }
 
+// IsLevelEnabled reports whether the hook fires the entries of `level`, e.g. to skip
+// building an expensive field which would not be sent:
+//
+// if hook.IsLevelEnabled(logrus.DebugLevel) { log.WithField("state", dump()).Debug("state") }
+//
+// Like `Fire`, a hook with no levels fires the entries of all levels.
+func (h *Hook) IsLevelEnabled(level logrus.Level) bool {
+	levels := h.enabledLevels()
+	return len(levels) == 0 || hasLevel(levels, level)
+}
+
+// SetLevels replaces the levels of the hook with `levels`.
+// The levels are deduplicated and sorted in the order of `logrus.AllLevels`,
+// and the slice is copied so changing it afterwards doesn't change the hook.
+func (h *Hook) SetLevels(levels []logrus.Level) {
+	levels = uniqueLevels(levels)
+
+	h.levelsMu.Lock()
+	defer h.levelsMu.Unlock()
+
+	h.levels = levels
+}
+
+// GetLevels returns a copy of the levels of the hook. It is the same as `Levels`.
+func (h *Hook) GetLevels() []logrus.Level {
+	return h.Levels()
+}
+
 func (h *Hook) SetLevel(level logrus.Level) {
 	var levels []logrus.Level
 
@@ -64,10 +533,16 @@ func (h *Hook) SetLevel(level logrus.Level) {
 		}
 	}
 
+	h.levelsMu.Lock()
+	defer h.levelsMu.Unlock()
+
 	h.levels = levels
 }
 
 func (h *Hook) RemoveLevel(level logrus.Level) {
+	h.levelsMu.Lock()
+	defer h.levelsMu.Unlock()
+
 	var levels []logrus.Level
 
 	for _, l := range h.levels {
@@ -79,6 +554,28 @@ func (h *Hook) RemoveLevel(level logrus.Level) {
 	h.levels = levels
 }
 
+// uniqueLevels returns a sorted copy of `levels` without duplicates.
+func uniqueLevels(levels []logrus.Level) []logrus.Level {
+	unique := make([]logrus.Level, 0, len(levels))
+	for _, l := range levels {
+		if !hasLevel(unique, l) {
+			unique = append(unique, l)
+		}
+	}
+	sort.Slice(unique, func(i, j int) bool { return unique[i] < unique[j] })
+	return unique
+}
+
+// hasLevel reports whether `level` is one of `levels`.
+func hasLevel(levels []logrus.Level, level logrus.Level) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
 // Using a pool to re-use of old entries when formatting Logstash messages.
 // It is used in the Fire function.
 var entryPool = sync.Pool{
@@ -87,23 +584,32 @@ var entryPool = sync.Pool{
 	},
 }
 
-// copyEntry copies the entry `e` to a new entry and then adds all the fields in `fields` that are missing in the new entry data.
+// copyEntry copies the entry `e` to a new entry, including its data and its buffer.
 // It uses `entryPool` to re-use allocated entries.
-func copyEntry(e *logrus.Entry, fields logrus.Fields) *logrus.Entry {
+func copyEntry(e *logrus.Entry) *logrus.Entry {
 	ne := entryPool.Get().(*logrus.Entry)
 	ne.Message = e.Message
 	ne.Level = e.Level
 	ne.Time = e.Time
-	ne.Data = logrus.Fields{}
-	for k, v := range fields {
-		ne.Data[k] = v
-	}
+	ne.Caller = e.Caller
+	ne.Context = e.Context
+	ne.Buffer = e.Buffer
+	ne.Data = make(logrus.Fields, len(e.Data))
 	for k, v := range e.Data {
 		ne.Data[k] = v
 	}
 	return ne
 }
 
+// addMissingFields adds all the fields in `fields` that are missing in `data`.
+func addMissingFields(data logrus.Fields, fields logrus.Fields) {
+	for k, v := range fields {
+		if _, ok := data[k]; !ok {
+			data[k] = v
+		}
+	}
+}
+
 // releaseEntry puts the given entry back to `entryPool`. It must be called if copyEntry is called.
 func releaseEntry(e *logrus.Entry) {
 	entryPool.Put(e)
@@ -112,13 +618,248 @@ func releaseEntry(e *logrus.Entry) {
 // LogstashFormatter represents a Logstash format.
 // It has logrus.Formatter which formats the entry and logrus.Fields which
 // are added to the JSON message if not given in the entry data.
+// When logrus.Formatter is nil, the entry is encoded to JSON by LogstashFormatter itself,
+// which is what the formatters returned by `NewFormatter` and `DefaultFormatter` do.
+//
+// KeyMap, when set, renames the keys of the entry data. See `DefaultFormatterWithKeyMap`.
 //
 // Note: use the `DefaultFormatter` function to set a default Logstash formatter.
 type LogstashFormatter struct {
 	logrus.Formatter
 	logrus.Fields
+	KeyMap map[string]string
+
+	// fieldMap and timestampFormat configure the JSON encoding of the entry. See `FormatterConfig`.
+	fieldMap         logrus.FieldMap
+	timestampFormat  string
+	timestampField   string
+	clock            Clock
+	orderedKeys      bool
+	marshalFunc      MarshalFunc
+	rawFields        map[string]bool
+	levelNames       map[logrus.Level]string
+	levelFieldKey    string
+	levelFieldValues map[logrus.Level]interface{}
+	collisionPolicy  CollisionPolicy
+	onError          func(*logrus.Entry, error)
+
+	allowed  map[string]bool
+	denied   map[string]bool
+	redactor Redactor
+
+	hostnameKey      string
+	hostname         string
+	serviceKey       string
+	tags             []string
+	service          string
+	caller           bool
+	goroutineID      bool
+	stackTrace       bool
+	structuredErrors bool
+	sequenceKey      string
+	sequence         *uint64
+
+	globals *globalFields
+
+	contextExtractor   ContextExtractor
+	fieldProvider      FieldProvider
+	rawKey             string
+	rawFormatter       logrus.Formatter
+	messageTransformer MessageTransformer
+
+	fieldTypes         map[string]FieldType
+	durationFormat     DurationFormat
+	omitEmpty          bool
+	maxMessageBytes    int
+	maxFieldValueBytes int
+	fieldPrefix        string
+	nestSeparator      string
+	maxDepth           int
+	flattenSeparator   string
+	flattenStructs     bool
+}
+
+// FormatterOption configures an optional behavior of the Logstash formatter.
+// Options are passed to `NewFormatter`, `DefaultFormatter` or `DefaultFormatterWithKeyMap`.
+type FormatterOption func(*LogstashFormatter)
+
+// WithFieldFilter removes the fields in `deny` from the entry data before it is formatted.
+// Only the entry data is filtered: the Logstash fields and the formatter's Fields can't be removed.
+func WithFieldFilter(deny []string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.denied = keySet(deny)
+	}
 }
 
+// WithAllowedFields removes the fields which are not in `allow` from the entry data before it is formatted.
+// Only the entry data is filtered: the Logstash fields and the formatter's Fields can't be removed.
+func WithAllowedFields(allow []string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.allowed = keySet(allow)
+	}
+}
+
+// Redactor is called for every field of the entry data before it is formatted.
+// It returns the value to format instead of `value` and whether the field is kept at all.
+type Redactor func(key string, value interface{}) (interface{}, bool)
+
+// WithRedactor sets a Redactor which masks or removes the fields of the entry data,
+// e.g. to hide passwords and tokens while keeping their keys visible in Logstash:
+//
+//	logrustash.WithRedactor(func(key string, value interface{}) (interface{}, bool) {
+//		if key == "password" {
+//			return "[REDACTED]", true
+//		}
+//		return value, true
+//	})
+//
+// Like the field filters, it runs on the entry data only.
+func WithRedactor(r Redactor) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.redactor = r
+	}
+}
+
+// WithHostname adds the hostname of the machine to every entry under `key`, "host" if empty,
+// unless the entry data or the formatter's Fields already have it.
+// The hostname is read once when the option is applied. It is empty if it can't be read.
+func WithHostname(key string) FormatterOption {
+	if key == "" {
+		key = "host"
+	}
+	hostname, _ := os.Hostname()
+	return func(f *LogstashFormatter) {
+		f.hostnameKey = key
+		f.hostname = hostname
+	}
+}
+
+// WithServiceFromEnv adds the value of the environment variable `env`, "SERVICE_NAME" if empty,
+// to every entry under `key`, "service" if empty, unless the entry data or the formatter's Fields
+// already have it, e.g. to set the name of the service in its deployment:
+//
+// formatter := logrustash.DefaultFormatter(logrus.Fields{}, logrustash.WithServiceFromEnv("", ""))
+//
+// The variable is read once when the option is applied. The field is not added if it is unset or empty.
+func WithServiceFromEnv(env, key string) FormatterOption {
+	if env == "" {
+		env = "SERVICE_NAME"
+	}
+	if key == "" {
+		key = "service"
+	}
+	service := os.Getenv(env)
+	return func(f *LogstashFormatter) {
+		f.serviceKey = key
+		f.service = service
+	}
+}
+
+// WithCaller adds the "caller_file", "caller_line" and "caller_func" fields to the entries
+// which have caller information, i.e. when logrus' `SetReportCaller` is enabled.
+func WithCaller() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.caller = true
+	}
+}
+
+// ContextExtractor returns the fields to add to an entry from the context of the entry,
+// e.g. a request ID or a trace ID.
+type ContextExtractor func(ctx context.Context) logrus.Fields
+
+// WithContextExtractor adds the fields returned by `fn` to the entries which have a context,
+// i.e. which were logged with logrus' `WithContext`.
+// The fields of the entry take precedence over the extracted fields.
+func WithContextExtractor(fn ContextExtractor) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.contextExtractor = fn
+	}
+}
+
+// FieldProvider returns the fields to add to the entry `e`, computed when it is formatted,
+// e.g. the tenant of the current request.
+type FieldProvider func(e *logrus.Entry) logrus.Fields
+
+// WithFieldProvider adds the fields returned by `fn` to every entry.
+// Unlike the global fields, `fn` is called for every entry. The fields of the entry take precedence
+// over the provided fields. If `fn` panics, the entry is formatted without the provided fields
+// and the panic is reported to the handler set by `OnFormatError`.
+func WithFieldProvider(fn FieldProvider) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.fieldProvider = fn
+	}
+}
+
+// provideFields returns the fields of the formatter's field provider for the entry `e`,
+// or nil if the provider panics.
+func (f LogstashFormatter) provideFields(e *logrus.Entry) (fields logrus.Fields) {
+	defer func() {
+		if r := recover(); r != nil {
+			fields = nil
+			f.reportError(e, fmt.Errorf("logrustash: field provider panicked: %v", r))
+		}
+	}()
+	return f.fieldProvider(e)
+}
+
+// MessageTransformer returns the message to format instead of the message of an entry.
+type MessageTransformer func(message string) string
+
+// WithMessageTransformer replaces the message of every entry with the message returned by `fn`,
+// e.g. to strip the newlines which would break the framing of a Logstash input:
+//
+// logrustash.WithMessageTransformer(strings.NewReplacer("\n", " ", "\r", " ").Replace)
+func WithMessageTransformer(fn MessageTransformer) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.messageTransformer = fn
+	}
+}
+
+// keySet returns a set of the given keys.
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		set[k] = true
+	}
+	return set
+}
+
+// NoShipKey is the key of the entry field which, when set to true, keeps that entry from being sent
+// by the hook, e.g. for a noisy log line which is only useful locally:
+//
+// log.WithField(logrustash.NoShipKey, true).Debug("cache miss")
+//
+// The field is removed from the entries which are sent. Its key is set by `WithNoShipKey`.
+const NoShipKey = "_noship"
+
+// WithNoShipKey sets the key of the entry field which keeps an entry from being sent. See `NoShipKey`.
+// An empty key disables the field.
+func WithNoShipKey(key string) HookOption {
+	return func(h *Hook) {
+		h.noShipKey = key
+	}
+}
+
+// withoutField returns a shallow copy of `e` without the field `key`.
+func withoutField(e *logrus.Entry, key string) *logrus.Entry {
+	ne := *e
+	ne.Data = make(logrus.Fields, len(e.Data))
+	for k, v := range e.Data {
+		if k != key {
+			ne.Data[k] = v
+		}
+	}
+	return &ne
+}
+
+// TypeKey is the key of the entry field which sets the "type" of that entry only, e.g.
+// to send access logs and application logs with different types through the same hook:
+//
+// log.WithField(logrustash.TypeKey, "access").Info("GET /")
+//
+// The field itself is removed from the formatted entry.
+const TypeKey = "_logstash_type"
+
 var (
 	logstashFields   = logrus.Fields{"@version": "1", "type": "log"}
 	logstashFieldMap = logrus.FieldMap{
@@ -127,31 +868,345 @@ var (
 	}
 )
 
-// DefaultFormatter returns a default Logstash formatter:
-// A JSON format with "@version" set to "1" (unless set differently in `fields`,
-// "type" to "log" (unless set differently in `fields`),
+// FormatterConfig configures the Logstash formatter returned by `NewFormatter`.
+type FormatterConfig struct {
+	// Fields are added to the entry data unless already set in it. See `LogstashFormatter`.
+	Fields logrus.Fields
+	// KeyMap renames the output keys. See `DefaultFormatterWithKeyMap`.
+	KeyMap map[string]string
+	// Type is the value of "type" unless set differently in Fields. It defaults to "log".
+	Type string
+	// Version is the value of "@version" unless set differently in Fields. It defaults to "1".
+	Version string
+	// OmitVersion removes "@version" from the output unless set in Fields.
+	OmitVersion bool
+	// TimestampKey is the key of the log time. It defaults to "@timestamp".
+	TimestampKey string
+	// TimestampFormat is the layout of the log time, as in `time.Format`. It defaults to `time.RFC3339`.
+	// Use `TimestampEpochMillis` or `TimestampEpochNanos` for the log time to be a JSON number instead.
+	TimestampFormat string
+}
+
+// NewFormatter returns a Logstash formatter configured by `cfg`:
+// A JSON format with "@version" set to `cfg.Version`, "type" set to `cfg.Type`,
 // "@timestamp" to the log time and "message" to the log message.
 //
-// Note: to set a different configuration use the `LogstashFormatter` structure.
-func DefaultFormatter(fields logrus.Fields) logrus.Formatter {
+// logrustash.NewFormatter(logrustash.FormatterConfig{Type: "billing"})
+func NewFormatter(cfg FormatterConfig, opts ...FormatterOption) logrus.Formatter {
+	// The fields are copied so the package defaults and the caller's map are never changed.
+	fields := copyFields(cfg.Fields)
+	defaults := logrus.Fields{}
 	for k, v := range logstashFields {
+		defaults[k] = v
+	}
+	if cfg.Type != "" {
+		defaults["type"] = cfg.Type
+	}
+	if cfg.Version != "" {
+		defaults["@version"] = cfg.Version
+	}
+	if cfg.OmitVersion {
+		delete(defaults, "@version")
+	}
+	for k, v := range defaults {
 		if _, ok := fields[k]; !ok {
 			fields[k] = v
 		}
 	}
 
-	return LogstashFormatter{
-		Formatter: &logrus.JSONFormatter{FieldMap: logstashFieldMap},
-		Fields:    fields,
+	fieldMap := reservedKeys(logstashFieldMap)
+	if cfg.TimestampKey != "" {
+		fieldMap[logrus.FieldKeyTime] = cfg.TimestampKey
 	}
+	for k, v := range fieldMap {
+		fieldMap[k] = renameKey(v, cfg.KeyMap)
+	}
+
+	f := LogstashFormatter{
+		Fields:          fields,
+		KeyMap:          cfg.KeyMap,
+		fieldMap:        fieldMap,
+		timestampFormat: cfg.TimestampFormat,
+		globals:         &globalFields{},
+	}
+	for _, opt := range opts {
+		opt(&f)
+	}
+	return f
+}
+
+// DefaultFormatter returns a default Logstash formatter:
+// A JSON format with "@version" set to "1" (unless set differently in `fields`,
+// "type" to "log" (unless set differently in `fields`),
+// "@timestamp" to the log time and "message" to the log message.
+//
+// Note: to set a different configuration use the `NewFormatter` function or the `LogstashFormatter` structure.
+func DefaultFormatter(fields logrus.Fields, opts ...FormatterOption) logrus.Formatter {
+	return NewFormatter(FormatterConfig{Fields: fields}, opts...)
+}
+
+// DefaultFormatterWithKeyMap returns a default Logstash formatter (see `DefaultFormatter`)
+// whose output keys are renamed according to `keyMap`.
+// Both the Logstash keys ("@timestamp", "message", "level", "@version" and "type")
+// and the keys of the entry data are renamed, e.g. to use "msg" instead of "message":
+//
+// logrustash.DefaultFormatterWithKeyMap(logrus.Fields{}, map[string]string{"message": "msg"})
+//
+// Formatting an entry returns an error when two keys of the entry data are renamed to the same key.
+// The keys of the entry data which are, or are renamed to, the time, message or level keys are
+// renamed or removed by the collision policy first, so the Logstash keys win. See `WithCollisionPolicy`.
+func DefaultFormatterWithKeyMap(fields logrus.Fields, keyMap map[string]string, opts ...FormatterOption) logrus.Formatter {
+	return NewFormatter(FormatterConfig{Fields: fields, KeyMap: keyMap}, opts...)
 }
 
 // Format formats an entry to a Logstash format according to the given Formatter and Fields.
 //
 // Note: the given entry is copied and not changed during the formatting process.
 func (f LogstashFormatter) Format(e *logrus.Entry) ([]byte, error) {
-	ne := copyEntry(e, f.Fields)
-	dataBytes, err := f.Formatter.Format(ne)
-	releaseEntry(ne)
-	return dataBytes, err
+	ne := copyEntry(e)
+	defer releaseEntry(ne)
+
+	if err := f.prepare(ne); err != nil {
+		return nil, err
+	}
+	out, err := f.encode(ne)
+	if err != nil || f.maxMessageBytes <= 0 || len(out) <= f.maxMessageBytes {
+		return out, err
+	}
+	return f.truncateMessage(ne)
+}
+
+// encode encodes the prepared entry `ne` to JSON or with `LogstashFormatter.Formatter`, if set.
+func (f LogstashFormatter) encode(ne *logrus.Entry) ([]byte, error) {
+	if f.Formatter == nil {
+		return f.encodeJSON(ne)
+	}
+	return f.Formatter.Format(ne)
+}
+
+// prepare applies the fields and the options of the formatter to `ne`, a copy of the formatted entry.
+func (f LogstashFormatter) prepare(ne *logrus.Entry) error {
+	if f.rawFormatter != nil {
+		if raw, ok := f.rawOutput(ne); ok {
+			addMissingFields(ne.Data, logrus.Fields{f.rawKey: raw})
+		}
+	}
+	if f.messageTransformer != nil {
+		ne.Message = f.messageTransformer(ne.Message)
+	}
+	if t, ok := ne.Data[TypeKey]; ok {
+		delete(ne.Data, TypeKey)
+		ne.Data["type"] = t
+	}
+	eventTimeSet := false
+	if f.timestampField != "" {
+		if t, ok := eventTime(ne.Data, f.timestampField); ok {
+			ne.Time = t
+			delete(ne.Data, f.timestampField)
+			eventTimeSet = true
+		}
+	}
+	if f.clock != nil && !eventTimeSet {
+		ne.Time = f.clock.Now()
+	} else if ne.Time.IsZero() {
+		// e.g. an entry which was not created by logrus.
+		ne.Time = realClock{}.Now()
+	}
+	if f.contextExtractor != nil && ne.Context != nil {
+		addMissingFields(ne.Data, f.contextExtractor(ne.Context))
+	}
+	if f.fieldProvider != nil {
+		addMissingFields(ne.Data, f.provideFields(ne))
+	}
+	f.filterFields(ne.Data)
+	f.redactFields(ne.Data)
+	if f.stackTrace {
+		addMissingFields(ne.Data, stackTraces(ne.Data))
+	}
+	addMissingFields(ne.Data, f.globals.load())
+	addMissingFields(ne.Data, f.Fields)
+	if f.hostnameKey != "" {
+		addMissingFields(ne.Data, logrus.Fields{f.hostnameKey: f.hostname})
+	}
+	if f.service != "" {
+		addMissingFields(ne.Data, logrus.Fields{f.serviceKey: f.service})
+	}
+	if v, ok := f.levelFieldValues[ne.Level]; ok {
+		addMissingFields(ne.Data, logrus.Fields{f.levelFieldKey: v})
+	}
+	if len(f.tags) > 0 {
+		mergeTags(ne.Data, f.tags)
+	}
+	if f.caller && ne.Caller != nil {
+		addMissingFields(ne.Data, logrus.Fields{
+			"caller_file": ne.Caller.File,
+			"caller_line": ne.Caller.Line,
+			"caller_func": ne.Caller.Function,
+		})
+	}
+	if f.goroutineID {
+		if id := goroutineID(); id != 0 {
+			addMissingFields(ne.Data, logrus.Fields{"goroutine_id": id})
+		}
+	}
+	if f.sequence != nil {
+		addMissingFields(ne.Data, logrus.Fields{f.sequenceKey: atomic.AddUint64(f.sequence, 1)})
+	}
+	if f.flattenSeparator != "" {
+		ne.Data = flattenFields(ne.Data, f.flattenSeparator, f.flattenStructs)
+	}
+	if f.durationFormat != DurationNanoseconds {
+		formatDurations(ne.Data, f.durationFormat)
+	}
+	if f.fieldTypes != nil {
+		f.convertFields(ne)
+	}
+	if f.maxFieldValueBytes > 0 {
+		truncateFields(ne.Data, f.maxFieldValueBytes)
+	}
+	if f.omitEmpty {
+		omitEmptyFields(ne.Data)
+	}
+
+	if f.KeyMap != nil {
+		f.resolveRenamedClashes(ne.Data, f.outputKeys())
+		data, err := renameKeys(ne.Data, f.KeyMap, f.outputKeys())
+		if err != nil {
+			return err
+		}
+		ne.Data = data
+	}
+	if f.fieldPrefix != "" {
+		ne.Data = f.prefixFields(ne.Data)
+	}
+	if f.nestSeparator != "" {
+		data, err := nestFields(ne.Data, f.nestSeparator)
+		if err != nil {
+			return err
+		}
+		ne.Data = data
+	}
+	if f.maxDepth > 0 {
+		f.limitDepths(ne.Data)
+	}
+	return nil
+}
+
+// outputKeys returns the time, message and level keys of the formatter output.
+func (f LogstashFormatter) outputKeys() logrus.FieldMap {
+	if f.fieldMap != nil {
+		return f.fieldMap
+	}
+	keys := reservedKeys(logstashFieldMap)
+	for k, v := range keys {
+		keys[k] = renameKey(v, f.KeyMap)
+	}
+	return keys
+}
+
+// filterFields removes the fields of `data` which are denied or not allowed.
+func (f LogstashFormatter) filterFields(data logrus.Fields) {
+	if f.allowed == nil && f.denied == nil {
+		return
+	}
+	for k := range data {
+		if f.denied[k] || (f.allowed != nil && !f.allowed[k]) {
+			delete(data, k)
+		}
+	}
+}
+
+// redactFields replaces the values of `data` by the ones returned by the formatter's Redactor.
+func (f LogstashFormatter) redactFields(data logrus.Fields) {
+	if f.redactor == nil {
+		return
+	}
+	for k, v := range data {
+		nv, keep := f.redactor(k, v)
+		if !keep {
+			delete(data, k)
+			continue
+		}
+		data[k] = nv
+	}
+}
+
+// reservedKeys returns the keys which are set by the formatter itself:
+// the time, message and level keys according to `fieldMap`.
+func reservedKeys(fieldMap logrus.FieldMap) logrus.FieldMap {
+	reserved := logrus.FieldMap{
+		logrus.FieldKeyTime:  logrus.FieldKeyTime,
+		logrus.FieldKeyMsg:   logrus.FieldKeyMsg,
+		logrus.FieldKeyLevel: logrus.FieldKeyLevel,
+	}
+	for k, v := range fieldMap {
+		reserved[k] = v
+	}
+	return reserved
+}
+
+// renameKey returns the new name of `key` according to `keyMap`.
+func renameKey(key string, keyMap map[string]string) string {
+	if k, ok := keyMap[key]; ok {
+		return k
+	}
+	return key
+}
+
+// renameKeys returns a copy of `data` with its keys renamed according to `keyMap`.
+// It returns an error if two keys are renamed to the same key or if a key is renamed to one of the `reserved` keys.
+func renameKeys(data logrus.Fields, keyMap map[string]string, reserved logrus.FieldMap) (logrus.Fields, error) {
+	renamedFrom := make(map[string]string, len(data)+len(reserved))
+	for _, v := range reserved {
+		renamedFrom[v] = ""
+	}
+
+	// The keys are sorted for the reported collision to be deterministic.
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	renamed := make(logrus.Fields, len(data))
+	for _, k := range keys {
+		nk := renameKey(k, keyMap)
+		if from, ok := renamedFrom[nk]; ok {
+			if from == "" {
+				return nil, fmt.Errorf("logrustash: field %q collides with the reserved key %q", k, nk)
+			}
+			return nil, fmt.Errorf("logrustash: fields %q and %q are both renamed to %q", from, k, nk)
+		}
+		renamedFrom[nk] = k
+		renamed[nk] = data[k]
+	}
+	return renamed, nil
+}
+
+// multiError is an error which consists of several errors.
+type multiError []error
+
+func (m multiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// joinErrors returns an error that consists of the non-nil errors in `errs`, or nil if there are none.
+func joinErrors(errs ...error) error {
+	var m multiError
+	for _, err := range errs {
+		if err != nil {
+			m = append(m, err)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
 }
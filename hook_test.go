@@ -2,9 +2,15 @@ package logrustash
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -59,6 +65,96 @@ func TestFireFormatError(t *testing.T) {
 	}
 }
 
+type PartialFmt struct{}
+
+func (f PartialFmt) Format(e *logrus.Entry) ([]byte, error) {
+	return []byte("partial"), errors.New("failed")
+}
+
+func TestFireFormatErrorWritesNothing(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := New(buffer, PartialFmt{})
+	if err := h.Fire(&logrus.Entry{Data: logrus.Fields{}}); err == nil {
+		t.Error("expected Fire to return error")
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("expected nothing to be written but got '%s'", buffer.String())
+	}
+
+	buffer.Reset()
+	h = NewAsyncHook(buffer, PartialFmt{}, 10)
+	if err := h.Fire(&logrus.Entry{Data: logrus.Fields{}}); err == nil {
+		t.Error("expected Fire to return error")
+	}
+	h.Close()
+	if buffer.Len() != 0 {
+		t.Errorf("expected nothing to be written but got '%s'", buffer.String())
+	}
+}
+
+func TestHookWithWriterFunc(t *testing.T) {
+	var published []string
+	publish := func(p []byte) error {
+		published = append(published, string(p))
+		return nil
+	}
+	h := New(WriterFunc(func(p []byte) (int, error) {
+		return len(p), publish(p)
+	}), simpleFmter{})
+
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if !reflect.DeepEqual(published, []string{`msg: "a"`, `msg: "b"`}) {
+		t.Errorf("expected every entry to be published but got %v", published)
+	}
+}
+
+func TestFireNoShipField(t *testing.T) {
+	buffer := &CaptureBuffer{}
+	h := New(buffer, DefaultFormatter(logrus.Fields{}))
+
+	h.Fire(&logrus.Entry{Message: "local", Data: logrus.Fields{NoShipKey: true}})
+	e := &logrus.Entry{Message: "shipped", Data: logrus.Fields{NoShipKey: false, "id": 1}}
+	h.Fire(e)
+
+	entries := buffer.Entries()
+	if len(entries) != 1 || entries[0]["message"] != "shipped" {
+		t.Fatalf("expected only the shipped entry to be written but got %v", entries)
+	}
+	if _, ok := entries[0][NoShipKey]; ok || entries[0]["id"] != float64(1) {
+		t.Errorf("expected the no-ship field to be removed but got %v", entries[0])
+	}
+	if _, ok := e.Data[NoShipKey]; !ok {
+		t.Error("expected the fired entry to not be changed")
+	}
+
+	buffer.Reset()
+	h = New(buffer, DefaultFormatter(logrus.Fields{}), WithNoShipKey("local"))
+	h.Fire(&logrus.Entry{Message: "local", Data: logrus.Fields{"local": true}})
+	h.Fire(&logrus.Entry{Message: "shipped", Data: logrus.Fields{NoShipKey: true}})
+	if entries := buffer.Entries(); len(entries) != 1 || entries[0]["message"] != "shipped" {
+		t.Errorf("expected only the entry without the custom no-ship field to be written but got %v", entries)
+	}
+}
+
+func TestFireWithoutWriter(t *testing.T) {
+	if err := (&Hook{}).Fire(&logrus.Entry{Data: logrus.Fields{}}); err != ErrNoWriter {
+		t.Errorf("expected Fire on a zero hook to return ErrNoWriter but got %v", err)
+	}
+	if err := New(nil, simpleFmter{}).Fire(&logrus.Entry{Data: logrus.Fields{}}); err != ErrNoWriter {
+		t.Errorf("expected Fire to return ErrNoWriter but got %v", err)
+	}
+	if err := New(ioutil.Discard, nil).Fire(&logrus.Entry{Data: logrus.Fields{}}); err != ErrNoFormatter {
+		t.Errorf("expected Fire to return ErrNoFormatter but got %v", err)
+	}
+	if err := (&Hook{}).Close(); err != nil {
+		t.Errorf("expected Close on a zero hook to not return error: %s", err)
+	}
+}
+
 type FailWrite struct{}
 
 func (w FailWrite) Write(d []byte) (int, error) {
@@ -144,6 +240,35 @@ func TestLogstashFieldsNotOverridden(t *testing.T) {
 	}
 }
 
+func TestDefaultFormatterConcurrently(t *testing.T) {
+	shared := logrus.Fields{"app": "walrus"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			formatters := []logrus.Formatter{
+				DefaultFormatter(shared),
+				DefaultFormatter(logrus.Fields{"type": fmt.Sprint("type", i)}),
+			}
+			for _, formatter := range formatters {
+				if _, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{}}); err != nil {
+					t.Errorf("expected Format to not return error: %s", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(shared) != 1 {
+		t.Errorf("expected the fields passed to DefaultFormatter to not be changed but got %#v", shared)
+	}
+	if len(logstashFields) != 2 {
+		t.Errorf("expected logstashFields to not be changed but got %#v", logstashFields)
+	}
+}
+
 func TestFireWithLevels(t *testing.T) {
 	buffer := bytes.NewBuffer(nil)
 	h := Hook{
@@ -228,3 +353,678 @@ func TestHook_SetLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestHook_SetLevels(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	hook := New(buffer, simpleFmter{})
+
+	levels := []logrus.Level{logrus.ErrorLevel, logrus.InfoLevel}
+	hook.SetLevels(levels)
+	levels[0] = logrus.DebugLevel
+
+	got := hook.GetLevels()
+	if !reflect.DeepEqual(got, []logrus.Level{logrus.ErrorLevel, logrus.InfoLevel}) {
+		t.Fatalf("expected levels to be [error info] but got %v", got)
+	}
+	got[0] = logrus.TraceLevel
+	if hook.GetLevels()[0] != logrus.ErrorLevel {
+		t.Errorf("expected GetLevels to return a copy of the levels")
+	}
+
+	for _, level := range []logrus.Level{logrus.WarnLevel, logrus.InfoLevel, logrus.DebugLevel, logrus.ErrorLevel} {
+		if err := hook.Fire(&logrus.Entry{Message: level.String(), Level: level}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if buffer.String() != `msg: "info"msg: "error"` {
+		t.Errorf("expected only the info and error entries to be written but got '%s'", buffer.String())
+	}
+}
+
+func TestHook_SetLevelTwice(t *testing.T) {
+	hook := New(ioutil.Discard, simpleFmter{})
+
+	for i := 0; i < 3; i++ {
+		hook.SetLevel(logrus.InfoLevel)
+	}
+	expected := []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel, logrus.InfoLevel}
+	if !reflect.DeepEqual(hook.Levels(), expected) {
+		t.Errorf("expected levels to be %v but got %v", expected, hook.Levels())
+	}
+
+	hook.SetLevels([]logrus.Level{logrus.InfoLevel, logrus.ErrorLevel, logrus.InfoLevel, logrus.InfoLevel})
+	expected = []logrus.Level{logrus.ErrorLevel, logrus.InfoLevel}
+	if !reflect.DeepEqual(hook.Levels(), expected) {
+		t.Errorf("expected levels to be %v but got %v", expected, hook.Levels())
+	}
+}
+
+// countingFmter counts the entries it formats.
+type countingFmter struct {
+	count int
+}
+
+func (f *countingFmter) Format(e *logrus.Entry) ([]byte, error) {
+	f.count++
+	return []byte(e.Message), nil
+}
+
+func TestFireSkipsFormattingOfFilteredLevels(t *testing.T) {
+	formatter := &countingFmter{}
+	hook := New(ioutil.Discard, formatter)
+	hook.SetLevel(logrus.WarnLevel)
+
+	for _, level := range []logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel} {
+		if err := hook.Fire(&logrus.Entry{Level: level, Data: logrus.Fields{}}); err != nil {
+			t.Errorf("expected Fire to not return error: %s", err)
+		}
+	}
+	if formatter.count != 2 {
+		t.Errorf("expected only the warning and error entries to be formatted but got %d entries", formatter.count)
+	}
+}
+
+func TestHook_LevelsReturnsCopy(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	hook := New(buffer, simpleFmter{})
+	hook.SetLevels([]logrus.Level{logrus.ErrorLevel})
+
+	levels := hook.Levels()
+	levels[0] = logrus.DebugLevel
+
+	hook.Fire(&logrus.Entry{Message: "debug", Level: logrus.DebugLevel})
+	hook.Fire(&logrus.Entry{Message: "error", Level: logrus.ErrorLevel})
+	if buffer.String() != `msg: "error"` {
+		t.Errorf("expected only the error entry to be written but got '%s'", buffer.String())
+	}
+
+	New(ioutil.Discard, simpleFmter{}).Levels()[0] = logrus.DebugLevel
+	if logrus.AllLevels[0] != logrus.PanicLevel {
+		t.Errorf("expected the default levels to not be changed but got %v", logrus.AllLevels)
+	}
+}
+
+func TestHook_IsLevelEnabled(t *testing.T) {
+	hook := New(ioutil.Discard, simpleFmter{})
+	hook.SetLevel(logrus.WarnLevel)
+
+	if !hook.IsLevelEnabled(logrus.ErrorLevel) || !hook.IsLevelEnabled(logrus.WarnLevel) {
+		t.Errorf("expected the levels up to warning to be enabled in %v", hook.Levels())
+	}
+	if hook.IsLevelEnabled(logrus.InfoLevel) {
+		t.Errorf("expected the info level to not be enabled in %v", hook.Levels())
+	}
+
+	hook.SetLevels(nil)
+	if !hook.IsLevelEnabled(logrus.DebugLevel) {
+		t.Error("expected all the levels to be enabled for a hook with no levels")
+	}
+}
+
+func TestHook_LevelsConcurrently(t *testing.T) {
+	hook := New(ioutil.Discard, simpleFmter{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				hook.SetLevel(logrus.InfoLevel)
+				hook.RemoveLevel(logrus.WarnLevel)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if err := hook.Fire(&logrus.Entry{Level: logrus.InfoLevel}); err != nil {
+					t.Errorf("expected Fire to not return error: %s", err)
+				}
+				hook.Levels()
+				hook.IsLevelEnabled(logrus.WarnLevel)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDefaultFormatterWithKeyMap(t *testing.T) {
+	formatter := DefaultFormatterWithKeyMap(logrus.Fields{"type": "mylogs"}, map[string]string{
+		"message": "msg",
+		"level":   "severity",
+		"type":    "kind",
+		"f1":      "field1",
+	})
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "msg1",
+		Level:   logrus.InfoLevel,
+		Data:    logrus.Fields{"f1": "bla"},
+	})
+	if err != nil {
+		t.Errorf("expected Format to not return error: %s", err)
+	}
+
+	expected := []string{
+		`"msg":"msg1"`,
+		`"severity":"info"`,
+		`"kind":"mylogs"`,
+		`"field1":"bla"`,
+		`"@version":"1"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+	for _, unexp := range []string{`"message"`, `"level"`, `"type"`, `"f1"`} {
+		if strings.Contains(string(res), unexp) {
+			t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+		}
+	}
+}
+
+func TestDefaultFormatterWithKeyMapCollision(t *testing.T) {
+	formatter := DefaultFormatterWithKeyMap(logrus.Fields{}, map[string]string{
+		"message": "msg",
+		"f1":      "f",
+		"f2":      "f",
+	})
+
+	data := logrus.Fields{"f1": "bla", "f2": "bla"}
+	if _, err := formatter.Format(&logrus.Entry{Data: data}); err == nil {
+		t.Errorf("expected Format to return error for colliding fields %v", data)
+	}
+}
+
+func TestDefaultFormatterWithKeyMapReservedKeys(t *testing.T) {
+	formatter := DefaultFormatterWithKeyMap(logrus.Fields{}, map[string]string{"message": "msg"})
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "msg1",
+		Level:   logrus.InfoLevel,
+		Data:    logrus.Fields{"level": "user level", "msg": "user msg", "message": "user message"},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"msg":"msg1"`,
+		`"level":"info"`,
+		`"fields.level":"user level"`,
+		`"fields.msg":"user msg"`,
+		`"fields.message":"user message"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+
+	formatter = DefaultFormatterWithKeyMap(logrus.Fields{}, map[string]string{"message": "msg"}, WithCollisionPolicy(CollisionSuffix))
+	res, err = formatter.Format(&logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{"level": "user level"}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !strings.Contains(string(res), `"level_field":"user level"`) {
+		t.Errorf("expected to have the collision policy applied in '%s'", string(res))
+	}
+}
+
+func TestNewFormatter(t *testing.T) {
+	testData := []struct {
+		cfg        FormatterConfig
+		expected   []string
+		unexpected []string
+	}{
+		{
+			FormatterConfig{},
+			[]string{`"type":"log"`, `"@version":"1"`},
+			nil,
+		},
+		{
+			FormatterConfig{Type: "billing", Version: "2"},
+			[]string{`"type":"billing"`, `"@version":"2"`},
+			nil,
+		},
+		{
+			FormatterConfig{Type: "billing", OmitVersion: true},
+			[]string{`"type":"billing"`},
+			[]string{`"@version"`},
+		},
+		{
+			FormatterConfig{Fields: logrus.Fields{"type": "mylogs"}, Type: "billing"},
+			[]string{`"type":"mylogs"`},
+			nil,
+		},
+	}
+
+	for _, test := range testData {
+		res, err := NewFormatter(test.cfg).Format(&logrus.Entry{Data: logrus.Fields{}})
+		if err != nil {
+			t.Errorf("expected Format to not return error: %s", err)
+		}
+		for _, exp := range test.expected {
+			if !strings.Contains(string(res), exp) {
+				t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+			}
+		}
+		for _, unexp := range test.unexpected {
+			if strings.Contains(string(res), unexp) {
+				t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+			}
+		}
+	}
+}
+
+func TestFormatterFieldFilter(t *testing.T) {
+	entry := &logrus.Entry{
+		Message: "msg1",
+		Data:    logrus.Fields{"f1": "bla", "dump": "big", "password": "secret", "message": "my message"},
+	}
+
+	testData := []struct {
+		formatter  logrus.Formatter
+		expected   []string
+		unexpected []string
+	}{
+		{
+			DefaultFormatter(logrus.Fields{"ID": 123}, WithFieldFilter([]string{"dump", "password", "type"})),
+			[]string{`"f1":"bla"`, `"ID":123`, `"type":"log"`, `"message":"msg1"`},
+			[]string{`"dump"`, `"password"`},
+		},
+		{
+			DefaultFormatter(logrus.Fields{"ID": 123}, WithAllowedFields([]string{"f1"})),
+			[]string{`"f1":"bla"`, `"ID":123`, `"@version":"1"`, `"@timestamp"`, `"message":"msg1"`},
+			[]string{`"dump"`, `"password"`, `"my message"`},
+		},
+	}
+
+	for _, test := range testData {
+		res, err := test.formatter.Format(entry)
+		if err != nil {
+			t.Errorf("expected Format to not return error: %s", err)
+		}
+		for _, exp := range test.expected {
+			if !strings.Contains(string(res), exp) {
+				t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+			}
+		}
+		for _, unexp := range test.unexpected {
+			if strings.Contains(string(res), unexp) {
+				t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+			}
+		}
+	}
+}
+
+func TestFormatterRedactor(t *testing.T) {
+	type request struct {
+		Method string
+		Path   string
+	}
+	redactor := func(key string, value interface{}) (interface{}, bool) {
+		switch key {
+		case "password", "token":
+			return "[REDACTED]", true
+		case "authorization":
+			return nil, false
+		}
+		return value, true
+	}
+	formatter := DefaultFormatter(logrus.Fields{}, WithRedactor(redactor))
+
+	res, err := formatter.Format(&logrus.Entry{
+		Data: logrus.Fields{
+			"password":      "secret",
+			"token":         "abc",
+			"authorization": "Bearer abc",
+			"request":       request{Method: "GET", Path: "/"},
+		},
+	})
+	if err != nil {
+		t.Errorf("expected Format to not return error: %s", err)
+	}
+
+	expected := []string{
+		`"password":"[REDACTED]"`,
+		`"token":"[REDACTED]"`,
+		`"request":{"Method":"GET","Path":"/"}`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+	for _, unexp := range []string{"secret", "abc", "authorization"} {
+		if strings.Contains(string(res), unexp) {
+			t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+		}
+	}
+}
+
+func TestFormatterWithHostname(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	testData := []struct {
+		formatter logrus.Formatter
+		data      logrus.Fields
+		expected  string
+	}{
+		{DefaultFormatter(logrus.Fields{}, WithHostname("")), logrus.Fields{}, fmt.Sprintf(`"host":%q`, hostname)},
+		{DefaultFormatter(logrus.Fields{}, WithHostname("hostname")), logrus.Fields{}, fmt.Sprintf(`"hostname":%q`, hostname)},
+		{DefaultFormatter(logrus.Fields{}, WithHostname("")), logrus.Fields{"host": "web1"}, `"host":"web1"`},
+		{DefaultFormatter(logrus.Fields{"host": "web2"}, WithHostname("")), logrus.Fields{}, `"host":"web2"`},
+	}
+
+	for _, test := range testData {
+		res, err := test.formatter.Format(&logrus.Entry{Data: test.data})
+		if err != nil {
+			t.Errorf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), test.expected) {
+			t.Errorf("expected to have '%s' in '%s'", test.expected, string(res))
+		}
+	}
+}
+
+func TestFormatterWithServiceFromEnv(t *testing.T) {
+	os.Setenv("LOGRUSTASH_TEST_SERVICE", "billing")
+	defer os.Unsetenv("LOGRUSTASH_TEST_SERVICE")
+
+	testData := []struct {
+		formatter logrus.Formatter
+		data      logrus.Fields
+		expected  string
+	}{
+		{DefaultFormatter(logrus.Fields{}, WithServiceFromEnv("LOGRUSTASH_TEST_SERVICE", "")), logrus.Fields{}, `"service":"billing"`},
+		{DefaultFormatter(logrus.Fields{}, WithServiceFromEnv("LOGRUSTASH_TEST_SERVICE", "app")), logrus.Fields{}, `"app":"billing"`},
+		{DefaultFormatter(logrus.Fields{}, WithServiceFromEnv("LOGRUSTASH_TEST_SERVICE", "")), logrus.Fields{"service": "payments"}, `"service":"payments"`},
+	}
+
+	for _, test := range testData {
+		res, err := test.formatter.Format(&logrus.Entry{Data: test.data})
+		if err != nil {
+			t.Errorf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), test.expected) {
+			t.Errorf("expected to have '%s' in '%s'", test.expected, string(res))
+		}
+	}
+
+	res, err := DefaultFormatter(logrus.Fields{}, WithServiceFromEnv("LOGRUSTASH_TEST_UNSET", "")).Format(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if strings.Contains(string(res), "service") {
+		t.Errorf("expected no service field when the variable is unset in '%s'", string(res))
+	}
+}
+
+type closeRecorder struct {
+	bytes.Buffer
+	closed   bool
+	closeErr error
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return w.closeErr
+}
+
+func TestCloseClosesWriter(t *testing.T) {
+	w := &closeRecorder{}
+	h := New(w, simpleFmter{})
+
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+	if !w.closed {
+		t.Error("expected Close to close the writer")
+	}
+}
+
+type failCloser struct {
+	FailWrite
+}
+
+func (w failCloser) Close() error {
+	return errors.New("close failed")
+}
+
+func TestCloseReturnsFlushAndCloseErrors(t *testing.T) {
+	h := NewBatchHook(failCloser{}, simpleFmter{}, 1024, time.Hour)
+	h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+
+	err := h.Close()
+	if err == nil {
+		t.Fatal("expected Close to return error")
+	}
+	if m, ok := err.(multiError); !ok || len(m) != 2 {
+		t.Errorf("expected Close to return both the flush and the close errors but got %#v", err)
+	}
+}
+
+// flakyWriter fails the first `failures` writes.
+type flakyWriter struct {
+	bytes.Buffer
+	failures int
+	attempts int
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	w.attempts++
+	if w.attempts <= w.failures {
+		return 0, errors.New("connection refused")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestFireWithRetry(t *testing.T) {
+	testData := []struct {
+		failures    int
+		maxAttempts int
+		attempts    int
+		fails       bool
+	}{
+		{0, 3, 1, false},
+		{2, 3, 3, false},
+		{3, 3, 3, true},
+		{1, 0, 1, true},
+	}
+
+	for _, test := range testData {
+		w := &flakyWriter{failures: test.failures}
+		h := New(w, simpleFmter{}, WithRetry(test.maxAttempts, time.Millisecond))
+
+		err := h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+		if (err != nil) != test.fails {
+			t.Errorf("expected Fire error to be %v with %d failures and %d attempts but got %v", test.fails, test.failures, test.maxAttempts, err)
+		}
+		if w.attempts != test.attempts {
+			t.Errorf("expected %d write attempts but got %d", test.attempts, w.attempts)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	testData := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 30 * time.Second},
+		{100, 30 * time.Second},
+	}
+
+	for _, test := range testData {
+		if d := retryDelay(time.Second, test.attempt); d != test.expected {
+			t.Errorf("expected delay after attempt %d to be %s but got %s", test.attempt, test.expected, d)
+		}
+	}
+}
+
+func TestFormatterWithMessageTransformer(t *testing.T) {
+	strip := func(msg string) string {
+		return strings.NewReplacer("\n", "", "\r", "").Replace(msg)
+	}
+	formatter := DefaultFormatter(logrus.Fields{}, WithMessageTransformer(strip))
+
+	e := &logrus.Entry{Message: "first line\r\nsecond line\n", Data: logrus.Fields{}}
+	res, err := formatter.Format(e)
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !strings.Contains(string(res), `"message":"first linesecond line"`) {
+		t.Errorf("expected the newlines to be stripped from the message in '%s'", string(res))
+	}
+	if e.Message != "first line\r\nsecond line\n" {
+		t.Errorf("expected the entry message to not be changed but got %q", e.Message)
+	}
+}
+
+func TestFormatterWithFieldProvider(t *testing.T) {
+	calls := 0
+	provider := func(e *logrus.Entry) logrus.Fields {
+		calls++
+		return logrus.Fields{"tenant": fmt.Sprint("t", calls), "user": "provided"}
+	}
+	formatter := DefaultFormatter(logrus.Fields{}, WithFieldProvider(provider))
+
+	for i, expected := range []string{`"tenant":"t1"`, `"tenant":"t2"`} {
+		res, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{"user": "bob"}})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), expected) {
+			t.Errorf("expected to have '%s' in entry %d '%s'", expected, i, string(res))
+		}
+		if !strings.Contains(string(res), `"user":"bob"`) {
+			t.Errorf("expected the entry fields to take precedence in '%s'", string(res))
+		}
+	}
+}
+
+func TestFormatterWithPanickingFieldProvider(t *testing.T) {
+	var reported error
+	formatter := DefaultFormatter(logrus.Fields{},
+		WithFieldProvider(func(e *logrus.Entry) logrus.Fields { panic("no tenant") }),
+		OnFormatError(func(e *logrus.Entry, err error) { reported = err }),
+	)
+
+	res, err := formatter.Format(&logrus.Entry{Message: "hello", Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !strings.Contains(string(res), `"message":"hello"`) {
+		t.Errorf("expected the entry to be formatted but got '%s'", string(res))
+	}
+	if reported == nil || !strings.Contains(reported.Error(), "no tenant") {
+		t.Errorf("expected the panic to be reported but got %v", reported)
+	}
+}
+
+func TestFormatterWithTypeKey(t *testing.T) {
+	formatter := DefaultFormatterWithKeyMap(logrus.Fields{"type": "app"}, map[string]string{"type": "kind"})
+
+	testData := []struct {
+		data     logrus.Fields
+		expected string
+	}{
+		{logrus.Fields{}, `"kind":"app"`},
+		{logrus.Fields{TypeKey: "access"}, `"kind":"access"`},
+		{logrus.Fields{TypeKey: "access", "type": "other"}, `"kind":"access"`},
+	}
+
+	for _, test := range testData {
+		res, err := formatter.Format(&logrus.Entry{Data: test.data})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), test.expected) {
+			t.Errorf("expected to have '%s' in '%s'", test.expected, string(res))
+		}
+		if strings.Contains(string(res), TypeKey) {
+			t.Errorf("expected to not have '%s' in '%s'", TypeKey, string(res))
+		}
+	}
+}
+
+// chunkedWriter writes each byte separately, so concurrent writes would interleave.
+type chunkedWriter struct {
+	buffer bytes.Buffer
+}
+
+func (w *chunkedWriter) Write(p []byte) (int, error) {
+	for i := range p {
+		w.buffer.WriteByte(p[i])
+		runtime.Gosched()
+	}
+	return len(p), nil
+}
+
+func TestFireConcurrently(t *testing.T) {
+	w := &chunkedWriter{}
+	h := New(w, DefaultFormatter(logrus.Fields{"type": "walrus"}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				e := &logrus.Entry{Message: fmt.Sprint("message ", i, j), Data: logrus.Fields{"goroutine": i}}
+				if err := h.Fire(e); err != nil {
+					t.Errorf("expected Fire to not return error: %s", err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(w.buffer.String(), "\n"), "\n")
+	if len(lines) != 160 {
+		t.Fatalf("expected 160 lines but got %d", len(lines))
+	}
+	for _, line := range lines {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Errorf("expected '%s' to be valid JSON: %s", line, err)
+		}
+	}
+}
+
+func TestHookWithFallbackWriter(t *testing.T) {
+	conn := &fakeConn{buffer: &bytes.Buffer{}, broken: true}
+	fallback := &bytes.Buffer{}
+	h := New(conn, &simpleFmter{}, WithRetry(2, 0), WithFallbackWriter(fallback))
+
+	if err := h.Fire(&logrus.Entry{Message: "lost"}); err == nil {
+		t.Error("expected Fire to return the error of the writer")
+	}
+	if fallback.String() != `msg: "lost"` {
+		t.Errorf("expected the fallback writer to get the entry but got %q", fallback.String())
+	}
+	if s := h.Stats(); s.Retried != 1 || s.Failed != 1 {
+		t.Errorf("expected the entry to be written to the fallback after the retries but got %+v", s)
+	}
+
+	conn.broken = false
+	if err := h.Fire(&logrus.Entry{Message: "sent"}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if fallback.String() != `msg: "lost"` {
+		t.Errorf("expected the fallback writer to get the failed entries only but got %q", fallback.String())
+	}
+}
+
+func TestHookWithFailingFallbackWriter(t *testing.T) {
+	conn := &fakeConn{buffer: &bytes.Buffer{}, broken: true}
+	h := New(conn, &simpleFmter{}, WithFallbackWriter(&fakeConn{broken: true}))
+
+	if err := h.Fire(&logrus.Entry{Message: "lost"}); err == nil || err.Error() != "broken pipe" {
+		t.Errorf("expected Fire to return the error of the writer but got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewHTTPHook returns a new logrus.Hook for Logstash that sends every entry in a POST request to `rawurl`,
+// e.g. for the Logstash `http` input. The requests are sent with the client set by `WithHTTPClient`
+// and the headers set by `WithHTTPHeader` and `WithBasicAuth`, and have a "Content-Type" of
+// "application/json" unless set differently.
+// A response with a status other than 2xx fails the write like a network error, so it is
+// retried as configured by `WithRetry`, and its error is returned by `Fire` or reported to `OnError`.
+//
+// hook, err := logrustash.NewHTTPHook("https://logstash.corp.io:8080", logrustash.DefaultFormatter(logrus.Fields{}), logrustash.WithBasicAuth("app", "secret"))
+func NewHTTPHook(rawurl string, f logrus.Formatter, opts ...HookOption) (*Hook, error) {
+	if _, err := url.Parse(rawurl); err != nil {
+		return nil, err
+	}
+	h := newHook(f, opts...)
+	h.setWriter(h.wrapWriter(h.newHTTPWriter(rawurl)))
+	return h, nil
+}
+
+// NewHTTPWriter returns a writer which sends every write in a POST request to `rawurl`, like a hook
+// created by `NewHTTPHook` with the same options, so the entries can be posted in batches:
+//
+// w, err := logrustash.NewHTTPWriter("https://logstash.corp.io:8080", logrustash.WithBasicAuth("app", "secret"))
+// hook := logrustash.NewJSONArrayBatchHook(w, logrustash.DefaultFormatter(logrus.Fields{}), 100, time.Second)
+//
+// Only `WithHTTPClient`, `WithHTTPHeader` and `WithBasicAuth` apply to the writer; the other options are ignored.
+func NewHTTPWriter(rawurl string, opts ...HookOption) (io.Writer, error) {
+	if _, err := url.Parse(rawurl); err != nil {
+		return nil, err
+	}
+	h := &Hook{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h.newHTTPWriter(rawurl), nil
+}
+
+// newHTTPWriter returns the writer of the requests to `rawurl` with the HTTP options of the hook.
+func (h *Hook) newHTTPWriter(rawurl string) *httpWriter {
+	client := h.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &httpWriter{url: rawurl, client: client, header: h.httpHeader}
+}
+
+// WithHTTPClient sets the client of the requests of a hook created by `NewHTTPHook` or of `NewHTTPWriter`,
+// e.g. to configure TLS, a proxy or a timeout. It defaults to `http.DefaultClient`.
+func WithHTTPClient(c *http.Client) HookOption {
+	return func(h *Hook) {
+		h.httpClient = c
+	}
+}
+
+// WithHTTPHeader adds the header `key` with `value` to the requests of a hook created by `NewHTTPHook`
+// or of `NewHTTPWriter`.
+func WithHTTPHeader(key, value string) HookOption {
+	return func(h *Hook) {
+		if h.httpHeader == nil {
+			h.httpHeader = http.Header{}
+		}
+		h.httpHeader.Add(key, value)
+	}
+}
+
+// WithBasicAuth authenticates the requests of a hook created by `NewHTTPHook` or of `NewHTTPWriter`
+// with HTTP basic authentication.
+func WithBasicAuth(username, password string) HookOption {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return func(h *Hook) {
+		if h.httpHeader == nil {
+			h.httpHeader = http.Header{}
+		}
+		h.httpHeader.Set("Authorization", "Basic "+auth)
+	}
+}
+
+// httpWriter sends the data written to it in POST requests.
+type httpWriter struct {
+	url    string
+	client *http.Client
+	header http.Header
+}
+
+// Write sends `p` in a POST request and fails unless the response status is 2xx.
+func (w *httpWriter) Write(p []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.header {
+		req.Header[k] = v
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// The body is read so the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("logrustash: %s responded with %s", w.url, resp.Status)
+	}
+	return len(p), nil
+}
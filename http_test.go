@@ -0,0 +1,123 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHTTPHook(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if r.Method != http.MethodPost || !ok || user != "app" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Tenant") != "acme" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	h, err := NewHTTPHook(server.URL, simpleFmter{}, WithBasicAuth("app", "secret"), WithHTTPHeader("X-Tenant", "acme"), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("expected NewHTTPHook to not return error: %s", err)
+	}
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(bodies, ",") != `msg: "a",msg: "b"` {
+		t.Errorf("expected every entry to be posted but got %v", bodies)
+	}
+}
+
+func TestHTTPHookNon2xxIsError(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	h, err := NewHTTPHook(server.URL, simpleFmter{}, WithRetry(2, time.Millisecond))
+	if err != nil {
+		t.Fatalf("expected NewHTTPHook to not return error: %s", err)
+	}
+	err = h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}})
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("expected Fire to return the response status but got %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 {
+		t.Errorf("expected the request to be retried once but got %d requests", requests)
+	}
+	if h.Stats().Failed != 1 {
+		t.Errorf("expected the entry to be counted as failed")
+	}
+}
+
+func TestJSONArrayBatchHookWithHTTPWriter(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("X-Tenant") != "acme" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	w, err := NewHTTPWriter(server.URL, WithHTTPHeader("X-Tenant", "acme"), WithHTTPClient(server.Client()))
+	if err != nil {
+		t.Fatalf("expected NewHTTPWriter to not return error: %s", err)
+	}
+	h := NewJSONArrayBatchHook(w, DefaultFormatter(logrus.Fields{}), 10, time.Hour)
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if err := h.Flush(time.Second); err != nil {
+		t.Fatalf("expected Flush to not return error: %s", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected the batch to be posted in one request but got %v", bodies)
+	}
+	var entries []map[string]interface{}
+	if err := json.Unmarshal([]byte(bodies[0]), &entries); err != nil {
+		t.Fatalf("expected the body to be a JSON array: %s", err)
+	}
+	if len(entries) != 2 || entries[0]["message"] != "a" || entries[1]["message"] != "b" {
+		t.Errorf("expected the body to hold both entries but got %v", entries)
+	}
+}
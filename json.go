@@ -0,0 +1,450 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TimestampEpochMillis is a `FormatterConfig.TimestampFormat` which encodes the log time
+	// as a JSON number of milliseconds since the Unix epoch.
+	TimestampEpochMillis = "epoch_millis"
+	// TimestampEpochNanos is a `FormatterConfig.TimestampFormat` which encodes the log time
+	// as a JSON number of nanoseconds since the Unix epoch.
+	TimestampEpochNanos = "epoch_nanos"
+	// TimestampRFC3339Nano is a `FormatterConfig.TimestampFormat` which encodes the log time in the RFC 3339
+	// format with nanoseconds. Unlike `time.RFC3339Nano`, the trailing zeros are kept, so the timestamps
+	// of the entries sort in the order of their log time.
+	TimestampRFC3339Nano = "2006-01-02T15:04:05.000000000Z07:00"
+)
+
+// Clock tells the current time. See `WithClock`.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock of the formatters created without `WithClock`.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock makes the formatter take the log time of every entry from `c` instead of
+// `logrus.Entry.Time`, e.g. so tests can check the exact timestamps of the entries, or to stamp
+// replayed entries from another time source. `WithTimestampField` still takes precedence.
+// Without it, only the entries whose time is zero, like the ones which were not created
+// by logrus, get the current time.
+func WithClock(c Clock) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.clock = c
+	}
+}
+
+// WithTimestampFormat sets the format of the log time like `FormatterConfig.TimestampFormat`,
+// e.g. to `TimestampRFC3339Nano` or `TimestampEpochNanos` so the entries logged in the same second
+// have different timestamps. It is useful with `DefaultFormatter`, which has no `FormatterConfig`.
+func WithTimestampFormat(format string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.timestampFormat = format
+	}
+}
+
+// encodeJSON encodes the entry `e` to a JSON message followed by a newline,
+// like logrus.JSONFormatter does but with the formatter's keys and timestamp format.
+// Like logrus.JSONFormatter, the message is written to `e.Buffer` if it is set, so the buffer
+// re-used by logrus saves an allocation.
+func (f LogstashFormatter) encodeJSON(e *logrus.Entry) ([]byte, error) {
+	keys := f.outputKeys()
+	data := f.outputFields(e, keys, f.timestamp(e.Time))
+
+	b := e.Buffer
+	if b == nil {
+		b = &bytes.Buffer{}
+	}
+	if f.orderedKeys {
+		return f.encodeOrderedJSON(e, b, data, keys)
+	}
+	err := f.encodeMap(b, data)
+	if err != nil {
+		// One bad field doesn't fail the whole entry: the fields which fail to be encoded are strings instead.
+		f.fallbackFields(e, data)
+		err = f.encodeMap(b, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("logrustash: failed to marshal fields to JSON: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// encodeMap writes the JSON encoding of `data` followed by a newline to `b`.
+// Nothing is written if it fails.
+func (f LogstashFormatter) encodeMap(b *bytes.Buffer, data logrus.Fields) error {
+	if f.marshalFunc != nil {
+		p, err := f.marshalFunc(data)
+		if err != nil {
+			return err
+		}
+		b.Write(p)
+		b.WriteByte('\n')
+		return nil
+	}
+	// The encoder writes the same encoding as json.Marshal followed by a newline.
+	return json.NewEncoder(b).Encode(data)
+}
+
+// fallbackFields replaces the fields of `data` which fail to be encoded to JSON by their "%+v" format.
+func (f LogstashFormatter) fallbackFields(e *logrus.Entry, data logrus.Fields) {
+	for k, v := range data {
+		if _, err := f.marshal(v); err != nil {
+			data[k] = f.fallbackValue(e, k, v, err)
+		}
+	}
+}
+
+// fallbackValue returns the "%+v" format of the value `v` of the field `k` which can't be encoded
+// to JSON because of `err`, and reports the error to the formatter's error handler.
+func (f LogstashFormatter) fallbackValue(e *logrus.Entry, k string, v interface{}, err error) string {
+	f.reportError(e, fmt.Errorf("logrustash: field %q is formatted as a string: %v", k, err))
+	return fmt.Sprintf("%+v", v)
+}
+
+// opaqueStruct reports whether `v` is a struct, or a pointer to a struct, whose fields are all unexported,
+// which `encoding/json` would encode as an empty object.
+func opaqueStruct(v interface{}) bool {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.NumField() == 0 {
+		return false
+	}
+	switch v.(type) {
+	case json.Marshaler, encoding.TextMarshaler:
+		return false
+	}
+	if rv.CanAddr() {
+		switch rv.Addr().Interface().(type) {
+		case json.Marshaler, encoding.TextMarshaler:
+			return false
+		}
+	}
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		// The fields of an embedded struct are encoded even if it is unexported.
+		if t.Field(i).PkgPath == "" || t.Field(i).Anonymous {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalFunc encodes a value to JSON. It has the signature of `json.Marshal`.
+type MarshalFunc func(v interface{}) ([]byte, error)
+
+// WithMarshalFunc makes the formatter encode the entries with `fn` instead of `json.Marshal`,
+// e.g. to use a faster JSON encoder. `fn` is given the map of the fields of an entry
+// or, with `WithOrderedKeys`, every key and value in turn.
+// It has no effect when `LogstashFormatter.Formatter` is set.
+func WithMarshalFunc(fn MarshalFunc) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.marshalFunc = fn
+	}
+}
+
+// marshal encodes `v` to JSON with the formatter's marshal function.
+func (f LogstashFormatter) marshal(v interface{}) ([]byte, error) {
+	if f.marshalFunc != nil {
+		return f.marshalFunc(v)
+	}
+	return json.Marshal(v)
+}
+
+// outputFields returns the fields of the formatter output for the entry `e`:
+// its data, with errors replaced by their message (see `WithStructuredErrors`), and the time `ts`,
+// the message and the level.
+func (f LogstashFormatter) outputFields(e *logrus.Entry, keys logrus.FieldMap, ts interface{}) logrus.Fields {
+	data := make(logrus.Fields, len(e.Data)+3)
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			// Otherwise errors are encoded as empty objects by `encoding/json`.
+			v = f.errorValue(err)
+		}
+		if raw, ok := f.rawValue(k, v); ok {
+			if json.Valid(raw) {
+				v = json.RawMessage(raw)
+			} else {
+				// Otherwise the whole entry fails to be encoded.
+				v = string(raw)
+				f.reportError(e, fmt.Errorf("logrustash: field %q is not valid JSON", k))
+			}
+		} else if opaqueStruct(v) {
+			// Otherwise its values are lost.
+			f.reportError(e, fmt.Errorf("logrustash: field %q has no exported fields and is formatted as a string", k))
+			v = fmt.Sprintf("%+v", v)
+		}
+		data[k] = v
+	}
+	f.resolveFieldClashes(data, keys)
+
+	data[keys[logrus.FieldKeyTime]] = ts
+	data[keys[logrus.FieldKeyMsg]] = e.Message
+	data[keys[logrus.FieldKeyLevel]] = f.levelName(e.Level)
+	return data
+}
+
+// WithLevelNames makes the formatter write the levels in `names` with their name in `names`
+// instead of their logrus name, e.g. "WARN" instead of "warning".
+// The other levels keep their logrus name.
+func WithLevelNames(names map[logrus.Level]string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.levelNames = make(map[logrus.Level]string, len(names))
+		for l, name := range names {
+			f.levelNames[l] = name
+		}
+	}
+}
+
+// levelName returns the name of the level `l` in the formatter output.
+func (f LogstashFormatter) levelName(l logrus.Level) string {
+	if name, ok := f.levelNames[l]; ok {
+		return name
+	}
+	return l.String()
+}
+
+// WithLevelField adds the value of the level of every entry in `values` as the field `key`,
+// e.g. a numeric severity for an Elasticsearch ILM policy:
+//
+// logrustash.WithLevelField("severity_num", map[logrus.Level]interface{}{logrus.ErrorLevel: 3, logrus.WarnLevel: 4})
+//
+// The entries of the other levels don't have the field, and an entry which already has the field keeps its value.
+func WithLevelField(key string, values map[logrus.Level]interface{}) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.levelFieldKey = key
+		f.levelFieldValues = make(map[logrus.Level]interface{}, len(values))
+		for l, v := range values {
+			f.levelFieldValues[l] = v
+		}
+	}
+}
+
+// WithRawFields makes the formatter write the values of the fields `keys` which are strings or
+// byte slices as JSON, e.g. for sub-documents which are already encoded.
+// The json.RawMessage values of any field are written as JSON too.
+// A value which isn't valid JSON is written as a string and reported to the handler set by `OnFormatError`.
+func WithRawFields(keys ...string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.rawFields = make(map[string]bool, len(keys))
+		for _, k := range keys {
+			f.rawFields[k] = true
+		}
+	}
+}
+
+// rawValue returns the JSON encoding of the value `v` of the field `k`, if it is already encoded.
+// See `WithRawFields`.
+func (f LogstashFormatter) rawValue(k string, v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case json.RawMessage:
+		return v, true
+	case []byte:
+		return v, f.rawFields[k]
+	case string:
+		return []byte(v), f.rawFields[k]
+	}
+	return nil, false
+}
+
+// OnFormatError sets the handler of the problems which the formatter works around instead of
+// failing to format the entry `e`, like a field which is not valid JSON (see `WithRawFields`), or a field
+// which can't be encoded to JSON, like a channel or a struct without exported fields, and is formatted
+// with "%+v" instead, or a field which can't be converted by `WithFieldTypes` and is removed.
+// It has the signature of the handler of the hook, so the same handler can be given to `OnError`.
+func OnFormatError(fn func(e *logrus.Entry, err error)) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.onError = fn
+	}
+}
+
+// reportError calls the formatter's error handler, if any.
+func (f LogstashFormatter) reportError(e *logrus.Entry, err error) {
+	if f.onError != nil {
+		f.onError(e, err)
+	}
+}
+
+// WithOrderedKeys makes the formatter write the Logstash keys first, in the order
+// "@timestamp", "@version", "message", "level" and "type" (or their names in the key map),
+// followed by the other keys in alphabetical order.
+// It makes the output easier to read and to compare, at the cost of sorting the keys of every entry.
+// It has no effect when `LogstashFormatter.Formatter` is set.
+func WithOrderedKeys() FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.orderedKeys = true
+	}
+}
+
+// encodeOrderedJSON writes the encoding of `data` to `b`: a JSON object followed by a newline,
+// with the keys ordered as described by `WithOrderedKeys`.
+func (f LogstashFormatter) encodeOrderedJSON(e *logrus.Entry, b *bytes.Buffer, data logrus.Fields, keys logrus.FieldMap) ([]byte, error) {
+	pinned := []string{
+		keys[logrus.FieldKeyTime],
+		renameKey("@version", f.KeyMap),
+		keys[logrus.FieldKeyMsg],
+		keys[logrus.FieldKeyLevel],
+		renameKey("type", f.KeyMap),
+	}
+	ordered := make([]string, 0, len(data))
+	for _, k := range pinned {
+		if _, ok := data[k]; ok && !containsString(ordered, k) {
+			ordered = append(ordered, k)
+		}
+	}
+	others := make([]string, 0, len(data))
+	for k := range data {
+		if !containsString(pinned, k) {
+			others = append(others, k)
+		}
+	}
+	sort.Strings(others)
+	ordered = append(ordered, others...)
+
+	b.WriteByte('{')
+	for i, k := range ordered {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		kb, err := f.marshal(k)
+		if err != nil {
+			return nil, fmt.Errorf("logrustash: failed to marshal fields to JSON: %v", err)
+		}
+		vb, err := f.marshal(data[k])
+		if err != nil {
+			vb, err = f.marshal(f.fallbackValue(e, k, data[k], err))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("logrustash: failed to marshal fields to JSON: %v", err)
+		}
+		b.Write(kb)
+		b.WriteByte(':')
+		b.Write(vb)
+	}
+	b.WriteString("}\n")
+	return b.Bytes(), nil
+}
+
+// containsString reports whether `s` is one of `list`.
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTimestampField makes the formatter take the log time of the entries which have the field `key`
+// from that field instead of `logrus.Entry.Time`, so an entry carrying its own event time
+// doesn't have two timestamps. The field is removed from the entry when its value is a time.Time
+// or a string in the RFC 3339 format. Otherwise, or when the entry doesn't have the field,
+// the log time is `logrus.Entry.Time` as usual.
+func WithTimestampField(key string) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.timestampField = key
+	}
+}
+
+// eventTime returns the time given by the timestamp field of `data`, if it has one. See `WithTimestampField`.
+func eventTime(data logrus.Fields, key string) (time.Time, bool) {
+	switch v := data[key].(type) {
+	case time.Time:
+		return v, true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// timestamp returns the log time `t` formatted according to the formatter's timestamp format.
+func (f LogstashFormatter) timestamp(t time.Time) interface{} {
+	switch f.timestampFormat {
+	case TimestampEpochMillis:
+		return t.UnixNano() / int64(time.Millisecond)
+	case TimestampEpochNanos:
+		return t.UnixNano()
+	case "":
+		return t.Format(time.RFC3339)
+	default:
+		return t.Format(f.timestampFormat)
+	}
+}
+
+// CollisionPolicy decides what the formatter does with a field of an entry whose key is
+// the time, message or level key of the output, e.g. a "level" field.
+// The time, message and level of the entry always win over such a field.
+type CollisionPolicy int
+
+const (
+	// CollisionPrefix renames the field by prefixing its key with "fields.", e.g. "fields.level",
+	// the same way logrus.JSONFormatter does. It is the default.
+	CollisionPrefix CollisionPolicy = iota
+	// CollisionSuffix renames the field by suffixing its key with "_field", e.g. "level_field".
+	CollisionSuffix
+	// CollisionDrop removes the field.
+	CollisionDrop
+)
+
+// WithCollisionPolicy sets what the formatter does with the fields which collide with
+// the time, message and level keys of the output.
+func WithCollisionPolicy(p CollisionPolicy) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.collisionPolicy = p
+	}
+}
+
+// resolveFieldClashes renames or removes the fields of `data` which clash with the time, message
+// and level keys according to the formatter's collision policy.
+func (f LogstashFormatter) resolveFieldClashes(data logrus.Fields, keys logrus.FieldMap) {
+	for _, k := range []string{keys[logrus.FieldKeyTime], keys[logrus.FieldKeyMsg], keys[logrus.FieldKeyLevel]} {
+		if v, ok := data[k]; ok {
+			f.resolveFieldClash(data, k, v)
+		}
+	}
+}
+
+// resolveRenamedClashes renames or removes the fields of `data` which `KeyMap` would rename to
+// the time, message or level keys `keys`, before they are renamed, so the reserved keys win.
+func (f LogstashFormatter) resolveRenamedClashes(data logrus.Fields, keys logrus.FieldMap) {
+	reserved := map[string]bool{keys[logrus.FieldKeyTime]: true, keys[logrus.FieldKeyMsg]: true, keys[logrus.FieldKeyLevel]: true}
+	var clashes []string
+	for k := range data {
+		if reserved[renameKey(k, f.KeyMap)] {
+			clashes = append(clashes, k)
+		}
+	}
+	for _, k := range clashes {
+		f.resolveFieldClash(data, k, data[k])
+	}
+}
+
+// resolveFieldClash renames or removes the field `k` of `data`, whose value is `v`,
+// according to the formatter's collision policy.
+func (f LogstashFormatter) resolveFieldClash(data logrus.Fields, k string, v interface{}) {
+	delete(data, k)
+	switch f.collisionPolicy {
+	case CollisionSuffix:
+		data[k+"_field"] = v
+	case CollisionDrop:
+	default:
+		data["fields."+k] = v
+	}
+}
@@ -0,0 +1,435 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFormatterTimestamp(t *testing.T) {
+	now := time.Date(2017, 5, 3, 10, 20, 30, 123456789, time.UTC)
+
+	testData := []struct {
+		cfg      FormatterConfig
+		expected string
+	}{
+		{FormatterConfig{}, `"@timestamp":"2017-05-03T10:20:30Z"`},
+		{FormatterConfig{TimestampKey: "ts", TimestampFormat: time.Kitchen}, `"ts":"10:20AM"`},
+		{FormatterConfig{TimestampKey: "ts", TimestampFormat: TimestampEpochMillis}, `"ts":1493806830123`},
+		{FormatterConfig{TimestampFormat: TimestampEpochNanos}, `"@timestamp":1493806830123456789`},
+	}
+
+	for _, test := range testData {
+		res, err := NewFormatter(test.cfg).Format(&logrus.Entry{Time: now, Data: logrus.Fields{}})
+		if err != nil {
+			t.Errorf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), test.expected) {
+			t.Errorf("expected to have '%s' in '%s'", test.expected, string(res))
+		}
+	}
+}
+
+func TestFormatterTimestampEpochMillisIsNumber(t *testing.T) {
+	formatter := NewFormatter(FormatterConfig{TimestampKey: "ts", TimestampFormat: TimestampEpochMillis})
+
+	res, err := formatter.Format(&logrus.Entry{Time: time.Now(), Data: logrus.Fields{}})
+	if err != nil {
+		t.Errorf("expected Format to not return error: %s", err)
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	if _, ok := m["ts"].(float64); !ok {
+		t.Errorf("expected ts to be a JSON number but got %#v", m["ts"])
+	}
+	if _, ok := m["@timestamp"]; ok {
+		t.Errorf("expected to not have @timestamp in '%s'", string(res))
+	}
+}
+
+func TestFormatterPrefixesFieldClashes(t *testing.T) {
+	res, err := DefaultFormatter(logrus.Fields{}).Format(&logrus.Entry{
+		Message: "msg1",
+		Data:    logrus.Fields{"message": "my message", "level": "custom"},
+	})
+	if err != nil {
+		t.Errorf("expected Format to not return error: %s", err)
+	}
+
+	expected := []string{
+		`"message":"msg1"`,
+		`"fields.message":"my message"`,
+		`"fields.level":"custom"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+}
+
+func TestFormatterWithCollisionPolicy(t *testing.T) {
+	testData := []struct {
+		policy     CollisionPolicy
+		expected   string
+		unexpected string
+	}{
+		{CollisionSuffix, `"level_field":"custom"`, `"fields.level"`},
+		{CollisionDrop, ``, `"custom"`},
+	}
+
+	for _, test := range testData {
+		res, err := DefaultFormatter(logrus.Fields{}, WithCollisionPolicy(test.policy)).Format(&logrus.Entry{
+			Level: logrus.WarnLevel,
+			Data:  logrus.Fields{"level": "custom"},
+		})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), `"level":"warning"`) {
+			t.Errorf("expected the level of the entry to win over the level field in '%s'", string(res))
+		}
+		if !strings.Contains(string(res), test.expected) {
+			t.Errorf("expected to have '%s' in '%s'", test.expected, string(res))
+		}
+		if strings.Contains(string(res), test.unexpected) {
+			t.Errorf("expected to not have '%s' in '%s'", test.unexpected, string(res))
+		}
+	}
+}
+
+func TestFormatterWithOrderedKeys(t *testing.T) {
+	now := time.Date(2017, 5, 3, 10, 20, 30, 0, time.UTC)
+	formatter := DefaultFormatter(logrus.Fields{"app": "walrus"}, WithOrderedKeys())
+
+	res, err := formatter.Format(&logrus.Entry{
+		Time:    now,
+		Message: "hello",
+		Level:   logrus.InfoLevel,
+		Data:    logrus.Fields{"zoo": 1, "beta": "b", "alpha": []int{1}},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := `{"@timestamp":"2017-05-03T10:20:30Z","@version":"1","message":"hello","level":"info","type":"log",` +
+		`"alpha":[1],"app":"walrus","beta":"b","zoo":1}` + "\n"
+	if string(res) != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, string(res))
+	}
+}
+
+func TestFormatterWithMarshalFunc(t *testing.T) {
+	calls := 0
+	marshal := func(v interface{}) ([]byte, error) {
+		calls++
+		return json.Marshal(v)
+	}
+	formatter := DefaultFormatter(logrus.Fields{}, WithMarshalFunc(marshal))
+
+	res, err := formatter.Format(&logrus.Entry{Message: "hello", Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if calls != 1 || !strings.HasSuffix(string(res), "}\n") || !strings.Contains(string(res), `"message":"hello"`) {
+		t.Errorf("expected the entry to be encoded by the marshal function but got '%s' after %d calls", string(res), calls)
+	}
+
+	failing := func(v interface{}) ([]byte, error) {
+		return nil, errors.New("unsupported")
+	}
+	for _, formatter := range []logrus.Formatter{
+		DefaultFormatter(logrus.Fields{}, WithMarshalFunc(failing)),
+		DefaultFormatter(logrus.Fields{}, WithMarshalFunc(failing), WithOrderedKeys()),
+	} {
+		_, err = formatter.Format(&logrus.Entry{Data: logrus.Fields{}})
+		if err == nil || err.Error() != "logrustash: failed to marshal fields to JSON: unsupported" {
+			t.Errorf("expected Format to return the marshal error but got %v", err)
+		}
+	}
+}
+
+func TestFormatterWithTimestampField(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithTimestampField("event_time"))
+	entryTime := time.Date(2017, 5, 3, 10, 20, 30, 0, time.UTC)
+
+	testData := []struct {
+		data      logrus.Fields
+		expected  string
+		eventTime bool
+	}{
+		{logrus.Fields{"event_time": time.Date(2017, 5, 2, 8, 0, 0, 0, time.UTC)}, "2017-05-02T08:00:00Z", false},
+		{logrus.Fields{"event_time": "2017-05-01T08:00:00.5Z"}, "2017-05-01T08:00:00Z", false},
+		{logrus.Fields{"event_time": "yesterday"}, "2017-05-03T10:20:30Z", true},
+		{logrus.Fields{}, "2017-05-03T10:20:30Z", false},
+	}
+
+	for _, test := range testData {
+		res, err := formatter.Format(&logrus.Entry{Time: entryTime, Data: test.data})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(res, &m); err != nil {
+			t.Fatalf("expected Unmarshal to not return error: %s", err)
+		}
+		if m["@timestamp"] != test.expected {
+			t.Errorf("expected @timestamp of %v to be %s but got %v", test.data, test.expected, m["@timestamp"])
+		}
+		if _, ok := m["event_time"]; ok != test.eventTime {
+			t.Errorf("expected event_time of %v to be kept: %t but got '%s'", test.data, test.eventTime, string(res))
+		}
+	}
+}
+
+func TestFormatterWithRawFields(t *testing.T) {
+	var reported []error
+	formatter := DefaultFormatter(logrus.Fields{}, WithRawFields("doc", "bytes"), OnFormatError(func(e *logrus.Entry, err error) {
+		reported = append(reported, err)
+	}))
+
+	res, err := formatter.Format(&logrus.Entry{
+		Data: logrus.Fields{
+			"doc":     `{"a":1}`,
+			"bytes":   []byte(`[1,2]`),
+			"raw":     json.RawMessage(`{"b":true}`),
+			"invalid": json.RawMessage(`{"c":`),
+			"text":    `{"d":1}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"doc":{"a":1}`,
+		`"bytes":[1,2]`,
+		`"raw":{"b":true}`,
+		`"invalid":"{\"c\":"`,
+		`"text":"{\"d\":1}"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+	if len(reported) != 1 || !strings.Contains(reported[0].Error(), `"invalid"`) {
+		t.Errorf("expected the invalid field to be reported but got %v", reported)
+	}
+}
+
+func TestFormatterWithLevelNames(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithLevelNames(map[logrus.Level]string{
+		logrus.WarnLevel:  "WARN",
+		logrus.DebugLevel: "DEBUG",
+	}))
+
+	testData := map[logrus.Level]string{
+		logrus.WarnLevel:  `"level":"WARN"`,
+		logrus.DebugLevel: `"level":"DEBUG"`,
+		logrus.InfoLevel:  `"level":"info"`,
+	}
+	for level, expected := range testData {
+		res, err := formatter.Format(&logrus.Entry{Level: level, Data: logrus.Fields{}})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), expected) {
+			t.Errorf("expected to have '%s' in '%s'", expected, string(res))
+		}
+	}
+}
+
+func TestFormatterWithLevelField(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithLevelField("severity_num", map[logrus.Level]interface{}{
+		logrus.ErrorLevel: 3,
+		logrus.WarnLevel:  4,
+	}))
+
+	testData := []struct {
+		level    logrus.Level
+		data     logrus.Fields
+		expected string
+	}{
+		{logrus.ErrorLevel, logrus.Fields{}, `"severity_num":3`},
+		{logrus.WarnLevel, logrus.Fields{}, `"severity_num":4`},
+		{logrus.WarnLevel, logrus.Fields{"severity_num": 9}, `"severity_num":9`},
+		{logrus.InfoLevel, logrus.Fields{}, ""},
+	}
+	for _, test := range testData {
+		res, err := formatter.Format(&logrus.Entry{Level: test.level, Data: test.data})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if test.expected == "" && strings.Contains(string(res), "severity_num") {
+			t.Errorf("expected no severity_num field for the %s level in '%s'", test.level, string(res))
+		}
+		if !strings.Contains(string(res), test.expected) {
+			t.Errorf("expected to have '%s' in '%s'", test.expected, string(res))
+		}
+	}
+}
+
+func TestFormatterWithTimestampFormatNanos(t *testing.T) {
+	first := time.Date(2017, 5, 3, 10, 20, 30, 100, time.UTC)
+	second := first.Add(50 * time.Nanosecond)
+
+	testData := map[string][]string{
+		TimestampRFC3339Nano: {`"@timestamp":"2017-05-03T10:20:30.000000100Z"`, `"@timestamp":"2017-05-03T10:20:30.000000150Z"`},
+		TimestampEpochNanos:  {`"@timestamp":1493806830000000100`, `"@timestamp":1493806830000000150`},
+	}
+	for format, expected := range testData {
+		formatter := DefaultFormatter(logrus.Fields{}, WithTimestampFormat(format))
+		for i, ts := range []time.Time{first, second} {
+			res, err := formatter.Format(&logrus.Entry{Time: ts, Data: logrus.Fields{}})
+			if err != nil {
+				t.Fatalf("expected Format to not return error: %s", err)
+			}
+			if !strings.Contains(string(res), expected[i]) {
+				t.Errorf("expected to have '%s' in '%s'", expected[i], string(res))
+			}
+		}
+	}
+}
+
+// fixedClock is a Clock which always tells the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestFormatterWithClock(t *testing.T) {
+	clock := fixedClock(time.Date(2017, 5, 3, 10, 20, 30, 0, time.UTC))
+	formatter := DefaultFormatter(logrus.Fields{}, WithClock(clock), WithTimestampField("event_time"))
+
+	for _, ts := range []time.Time{{}, time.Now()} {
+		res, err := formatter.Format(&logrus.Entry{Time: ts, Data: logrus.Fields{}})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), `"@timestamp":"2017-05-03T10:20:30Z"`) {
+			t.Errorf("expected the time of the clock in '%s'", string(res))
+		}
+	}
+
+	res, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{"event_time": "2016-01-01T00:00:00Z"}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !strings.Contains(string(res), `"@timestamp":"2016-01-01T00:00:00Z"`) {
+		t.Errorf("expected the timestamp field to take precedence over the clock in '%s'", string(res))
+	}
+}
+
+func TestFormatterZeroTime(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	res, err := DefaultFormatter(logrus.Fields{}, WithTimestampFormat(TimestampEpochNanos)).Format(&logrus.Entry{Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+	if ts, _ := m["@timestamp"].(float64); ts < float64(before.UnixNano()) {
+		t.Errorf("expected the zero time to be replaced by the current time in '%s'", string(res))
+	}
+
+	res, err = DefaultFormatter(logrus.Fields{}).Format(&logrus.Entry{Time: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !strings.Contains(string(res), `"@timestamp":"2016-01-01T00:00:00Z"`) {
+		t.Errorf("expected the entry time to be kept in '%s'", string(res))
+	}
+}
+
+func TestFormatterWritesToEntryBuffer(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	for _, formatter := range []logrus.Formatter{DefaultFormatter(logrus.Fields{}), DefaultFormatter(logrus.Fields{}, WithOrderedKeys())} {
+		buffer.Reset()
+		res, err := formatter.Format(&logrus.Entry{Message: "hello", Data: logrus.Fields{}, Buffer: buffer})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if buffer.String() != string(res) || !strings.Contains(buffer.String(), `"message":"hello"`) {
+			t.Errorf("expected the entry to be written to its buffer but got '%s'", buffer.String())
+		}
+	}
+}
+
+func BenchmarkDefaultFormatterWithoutBuffer(b *testing.B) {
+	formatter := DefaultFormatter(logrus.Fields{"app": "walrus"})
+	e := &logrus.Entry{Message: "hello", Time: time.Now(), Data: logrus.Fields{"id": 42, "method": "GET"}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		formatter.Format(e)
+	}
+}
+
+func BenchmarkDefaultFormatterWithBuffer(b *testing.B) {
+	formatter := DefaultFormatter(logrus.Fields{"app": "walrus"})
+	e := &logrus.Entry{Message: "hello", Time: time.Now(), Data: logrus.Fields{"id": 42, "method": "GET"}, Buffer: &bytes.Buffer{}}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e.Buffer.Reset()
+		formatter.Format(e)
+	}
+}
+
+type opaque struct {
+	id   int
+	name string
+}
+
+func TestFormatterFieldFallback(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		var reported []string
+		opts := []FormatterOption{OnFormatError(func(e *logrus.Entry, err error) {
+			reported = append(reported, err.Error())
+		})}
+		if ordered {
+			opts = append(opts, WithOrderedKeys())
+		}
+		formatter := DefaultFormatter(logrus.Fields{}, opts...)
+
+		res, err := formatter.Format(&logrus.Entry{
+			Message: "hello",
+			Data: logrus.Fields{
+				"ch":     make(chan int),
+				"user":   opaque{42, "walrus"},
+				"ptr":    &opaque{7, "seal"},
+				"time":   time.Unix(0, 0).UTC(),
+				"status": 200,
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(res, &m); err != nil {
+			t.Fatalf("expected Unmarshal to not return error: %s", err)
+		}
+		if s, ok := m["ch"].(string); !ok || !strings.HasPrefix(s, "0x") {
+			t.Errorf("expected the channel to be formatted as a string but got %#v", m["ch"])
+		}
+		if m["user"] != "{id:42 name:walrus}" || m["ptr"] != "&{id:7 name:seal}" {
+			t.Errorf("expected the structs without exported fields to be formatted with %%+v but got %#v and %#v", m["user"], m["ptr"])
+		}
+		if m["time"] != "1970-01-01T00:00:00Z" || m["status"] != float64(200) || m["message"] != "hello" {
+			t.Errorf("expected the other fields to be kept but got '%s'", string(res))
+		}
+		if len(reported) != 3 {
+			t.Errorf("expected the 3 fields to be reported but got %v", reported)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package logrustash
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StartupMarkerMessage is the message of the entry sent by a hook with `WithStartupMarker`.
+const StartupMarkerMessage = "logrustash hook initialized"
+
+// WithStartupMarker makes the hook send an info entry with the message `StartupMarkerMessage` and
+// `fields`, e.g. the version of the application, once it is connected, so Logstash shows when the
+// logging of every instance came online. The "pid" and "start_time" fields, the ID of the process
+// and the time the hook was created, are added unless `fields` has them.
+// The entry is not filtered by the levels, the sampling or the rate limit of the hook, but it is
+// framed and compressed like the other entries.
+//
+// The marker is sent once, when the hook is created, and is queued by an asynchronous hook so creating
+// it never waits for the writer. If it fails to be sent, the error is reported to the handler registered
+// by `OnError`, and a hook created by `NewHookWithReconnect` and the like sends it again once it
+// redials its connection, after the entry whose write redialed it.
+func WithStartupMarker(fields logrus.Fields) HookOption {
+	data := copyFields(fields)
+	addMissingFields(data, logrus.Fields{"pid": os.Getpid(), "start_time": time.Now()})
+	return func(h *Hook) {
+		h.marker = &startupMarker{fields: data}
+	}
+}
+
+// startupMarker is the entry sent by a hook once it is connected. See `WithStartupMarker`.
+type startupMarker struct {
+	fields logrus.Fields
+	// due is set to 1 when the marker must be sent: when the hook is created and when it dials
+	// a connection until the marker is sent. sent is set to 1 once it is. Both are accessed atomically.
+	due  int32
+	sent int32
+}
+
+// dialed makes the startup marker of the hook due unless it was sent. It is called by the connections
+// of the hook when they are dialed, with their mutex held, so the marker is sent later by
+// `sendStartupMarker`, once the hook holds no lock.
+func (h *Hook) dialed() {
+	if m := h.marker; m != nil && atomic.LoadInt32(&m.sent) == 0 {
+		atomic.StoreInt32(&m.due, 1)
+	}
+}
+
+// sendStartupMarker sends the startup marker of the hook if it is due: it is queued if `queued`
+// is true, or else written. It must be called while the hook holds no lock, since the errors are
+// reported to the handler registered by `OnError`.
+func (h *Hook) sendStartupMarker(queued bool) {
+	m := h.marker
+	if m == nil || atomic.LoadInt32(&m.sent) == 1 || !atomic.CompareAndSwapInt32(&m.due, 1, 0) {
+		return
+	}
+	e := &logrus.Entry{
+		Data:    copyFields(m.fields),
+		Time:    time.Now(),
+		Level:   logrus.InfoLevel,
+		Message: StartupMarkerMessage,
+	}
+	w, err := h.writerFor(e.Level, "")
+	var p []byte
+	if err == nil {
+		p, err = h.encode(e)
+	}
+	if err == nil {
+		if queued {
+			// The worker sets `sent` once the marker is written.
+			err = h.enqueue(e, w, p, true)
+		} else if err = h.write(w, p); err == nil {
+			atomic.StoreInt32(&m.sent, 1)
+		}
+	}
+	if err != nil {
+		h.reportError(e, err)
+	}
+}
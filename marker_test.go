@@ -0,0 +1,250 @@
+package logrustash
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHookWithStartupMarker(t *testing.T) {
+	buffer := &CaptureBuffer{}
+	h := New(buffer, DefaultFormatter(logrus.Fields{}), WithStartupMarker(logrus.Fields{"version": "1.2.3"}))
+	// The marker is sent even though the hook doesn't fire the info entries.
+	h.SetLevels([]logrus.Level{logrus.ErrorLevel})
+
+	if entries := buffer.Entries(); len(entries) != 1 {
+		t.Fatalf("expected the marker to be sent when the hook is created but got %v", entries)
+	}
+	for _, msg := range []string{"first", "second"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Level: logrus.ErrorLevel, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+
+	entries := buffer.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("expected the marker and 2 entries but got %v", entries)
+	}
+	marker := entries[0]
+	if marker["message"] != StartupMarkerMessage || marker["level"] != "info" || marker["version"] != "1.2.3" {
+		t.Errorf("expected the first entry to be the startup marker but got %v", marker)
+	}
+	if marker["pid"] != float64(os.Getpid()) || marker["start_time"] == nil {
+		t.Errorf("expected the marker to have the pid and the start time but got %v", marker)
+	}
+	if entries[1]["message"] != "first" || entries[2]["message"] != "second" {
+		t.Errorf("expected the entries to follow the marker but got %v", entries)
+	}
+}
+
+func TestHookWithStartupMarkerError(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	writes := 0
+	w := WriterFunc(func(p []byte) (int, error) {
+		if writes++; writes == 1 {
+			return 0, errors.New("unavailable")
+		}
+		return buffer.Write(p)
+	})
+	var errs []error
+	h := New(w, simpleFmter{}, WithStartupMarker(logrus.Fields{}), OnError(func(e *logrus.Entry, err error) {
+		errs = append(errs, err)
+	}))
+	if len(errs) != 1 {
+		t.Fatalf("expected the error of the marker to be reported once but got %v", errs)
+	}
+
+	for _, msg := range []string{"a", "b"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if buffer.String() != `msg: "a"msg: "b"` {
+		t.Errorf("expected the marker to not be sent by Fire but got '%s'", buffer.String())
+	}
+}
+
+func TestHookWithStartupMarkerRedial(t *testing.T) {
+	var conns []*fakeConn
+	d := func(network, address string) (net.Conn, error) {
+		if len(conns) == 1 {
+			// The connection redialed by the write of the marker fails.
+			conns = append(conns, nil)
+			return nil, errors.New("connection refused")
+		}
+		// The first connection breaks before the marker is written to it.
+		c := &fakeConn{buffer: &bytes.Buffer{}, broken: len(conns) == 0}
+		conns = append(conns, c)
+		return c, nil
+	}
+	h := newHook(simpleFmter{}, WithStartupMarker(logrus.Fields{}), WithReconnectBackoff(0))
+	var errs []error
+	// The handler logs through the hook, which must not hold a lock when it reports the error.
+	h.onError = func(e *logrus.Entry, err error) {
+		errs = append(errs, err)
+		h.Fire(&logrus.Entry{Message: "lost", Data: logrus.Fields{}})
+	}
+	c, err := dial("tcp", "logstash:9999", d, h.connConfig())
+	if err != nil {
+		t.Fatalf("expected dial to not return error: %s", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.setWriter(h.wrapWriter(c))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the error of the marker to be reported without a lock held")
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected the error of the marker to be reported once but got %v", errs)
+	}
+
+	if err := h.Fire(&logrus.Entry{Message: "a", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if len(conns) != 3 {
+		t.Fatalf("expected the connection to be dialed 3 times but it was dialed %d times", len(conns))
+	}
+	expected := `msg: "lost"msg: "` + StartupMarkerMessage + `"msg: "a"`
+	if conns[2].buffer.String() != expected {
+		t.Errorf("expected the marker to be sent once the connection is redialed but got '%s'", conns[2].buffer.String())
+	}
+
+	// The marker is sent once.
+	if err := h.Reconnect(); err != nil {
+		t.Fatalf("expected Reconnect to not return error: %s", err)
+	}
+	if conns[3].buffer.Len() != 0 {
+		t.Errorf("expected the marker to not be sent again but got '%s'", conns[3].buffer.String())
+	}
+}
+
+func TestHookWithStartupMarkerCompressionThreshold(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	New(buffer, simpleFmter{}, WithStartupMarker(logrus.Fields{}), WithCompressionThreshold(1024, func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	}))
+
+	b := buffer.Bytes()
+	expected := `msg: "` + StartupMarkerMessage + `"`
+	if len(b) < 5 || b[0] != FrameRaw || string(b[5:]) != expected {
+		t.Errorf("expected the marker to be sent in a raw frame but got %q", b)
+	}
+}
+
+func TestHookConstructorsWithStartupMarker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logrustash")
+	if err != nil {
+		t.Fatalf("expected TempDir to not return error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "logstash.json")
+
+	var mu sync.Mutex
+	posted := &bytes.Buffer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		io.Copy(posted, r.Body)
+	}))
+	defer server.Close()
+
+	opt := WithStartupMarker(logrus.Fields{})
+	f := DefaultFormatter(logrus.Fields{})
+	buffers := make([]*bytes.Buffer, 5)
+	for i := range buffers {
+		buffers[i] = &bytes.Buffer{}
+	}
+	testData := []struct {
+		name   string
+		hook   func() (*Hook, error)
+		output func() string
+	}{
+		{"NewBatchHook", func() (*Hook, error) {
+			return NewBatchHook(buffers[0], f, 1024, time.Hour, opt), nil
+		}, buffers[0].String},
+		{"NewJSONArrayBatchHook", func() (*Hook, error) {
+			return NewJSONArrayBatchHook(buffers[1], f, 10, time.Hour, opt), nil
+		}, buffers[1].String},
+		{"NewFanOutHook", func() (*Hook, error) {
+			return NewFanOutHook([]io.Writer{buffers[2]}, f, opt), nil
+		}, buffers[2].String},
+		{"NewAsyncHook", func() (*Hook, error) {
+			return NewAsyncHook(buffers[3], f, 10, opt), nil
+		}, buffers[3].String},
+		{"NewHookWithLevelWriters", func() (*Hook, error) {
+			return NewHookWithLevelWriters(map[logrus.Level]io.Writer{logrus.InfoLevel: buffers[4]}, nil, f, opt), nil
+		}, buffers[4].String},
+		{"NewFileHook", func() (*Hook, error) {
+			return NewFileHook(path, 0, f, opt)
+		}, func() string {
+			p, _ := ioutil.ReadFile(path)
+			return string(p)
+		}},
+		{"NewHTTPHook", func() (*Hook, error) {
+			return NewHTTPHook(server.URL, f, opt, WithHTTPClient(server.Client()))
+		}, func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			return posted.String()
+		}},
+	}
+
+	for _, test := range testData {
+		h, err := test.hook()
+		if err != nil {
+			t.Fatalf("%s: expected the constructor to not return error: %s", test.name, err)
+		}
+		// The batches and the queue are written by Close.
+		if err := h.Close(); err != nil {
+			t.Fatalf("%s: expected Close to not return error: %s", test.name, err)
+		}
+		if output := test.output(); !strings.Contains(output, `"message":"`+StartupMarkerMessage+`"`) {
+			t.Errorf("%s: expected the marker to be sent when the hook is created but got '%s'", test.name, output)
+		}
+	}
+}
+
+func TestAsyncHookWithStartupMarkerDoesNotBlock(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	release := make(chan struct{})
+	w := WriterFunc(func(p []byte) (int, error) {
+		<-release
+		return buffer.Write(p)
+	})
+
+	done := make(chan *Hook)
+	go func() {
+		done <- NewAsyncHook(w, simpleFmter{}, 10, WithStartupMarker(logrus.Fields{}))
+	}()
+	var h *Hook
+	select {
+	case h = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected NewAsyncHook to not wait for the marker to be written")
+	}
+	close(release)
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+	if buffer.String() != `msg: "`+StartupMarkerMessage+`"` {
+		t.Errorf("expected the marker to be written by the worker but got '%s'", buffer.String())
+	}
+}
@@ -0,0 +1,267 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MessagePackFormatter formats the entries like the formatter returned by `NewFormatter`, with the same
+// fields and options, but encodes them with MessagePack for the Logstash `msgpack` codec.
+// The log time is encoded with the MessagePack timestamp extension type unless
+// `FormatterConfig.TimestampFormat` is set. The values which are not maps, slices or basic types,
+// like structs, are encoded the same way `encoding/json` encodes them.
+//
+// Note: the entries are not terminated by a newline, so the hooks which send them over TCP
+// should use `WithFraming(FramingLengthPrefix)`.
+type MessagePackFormatter struct {
+	LogstashFormatter
+}
+
+// NewMessagePackFormatter returns a MessagePack formatter configured by `cfg` and `opts`. See `NewFormatter`.
+func NewMessagePackFormatter(cfg FormatterConfig, opts ...FormatterOption) MessagePackFormatter {
+	return MessagePackFormatter{NewFormatter(cfg, opts...).(LogstashFormatter)}
+}
+
+// Format formats an entry to a MessagePack map.
+//
+// Note: the given entry is copied and not changed during the formatting process.
+func (f MessagePackFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	ne := copyEntry(e)
+	defer releaseEntry(ne)
+
+	if err := f.prepare(ne); err != nil {
+		return nil, err
+	}
+	var ts interface{} = ne.Time
+	if f.timestampFormat != "" {
+		ts = f.timestamp(ne.Time)
+	}
+	data := f.outputFields(ne, f.outputKeys(), ts)
+
+	b := &bytes.Buffer{}
+	if err := encodeMsgpack(b, map[string]interface{}(data)); err != nil {
+		return nil, fmt.Errorf("logrustash: failed to marshal fields to MessagePack: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// encodeMsgpack writes the MessagePack encoding of `v` to `b`.
+func encodeMsgpack(b *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteByte(0xc0)
+	case bool:
+		if v {
+			b.WriteByte(0xc3)
+		} else {
+			b.WriteByte(0xc2)
+		}
+	case string:
+		writeMsgpackString(b, v)
+	case []byte:
+		writeMsgpackHeader(b, len(v), 0, 0xc4, 0xc5, 0xc6)
+		b.Write(v)
+	case int:
+		writeMsgpackInt(b, int64(v))
+	case int8:
+		writeMsgpackInt(b, int64(v))
+	case int16:
+		writeMsgpackInt(b, int64(v))
+	case int32:
+		writeMsgpackInt(b, int64(v))
+	case int64:
+		writeMsgpackInt(b, v)
+	case uint:
+		writeMsgpackUint(b, uint64(v))
+	case uint8:
+		writeMsgpackUint(b, uint64(v))
+	case uint16:
+		writeMsgpackUint(b, uint64(v))
+	case uint32:
+		writeMsgpackUint(b, uint64(v))
+	case uint64:
+		writeMsgpackUint(b, v)
+	case float32:
+		b.WriteByte(0xca)
+		binary.Write(b, binary.BigEndian, math.Float32bits(v))
+	case float64:
+		b.WriteByte(0xcb)
+		binary.Write(b, binary.BigEndian, math.Float64bits(v))
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(b, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		return encodeMsgpack(b, f)
+	case time.Time:
+		writeMsgpackTime(b, v)
+	case error:
+		if _, ok := v.(json.Marshaler); ok {
+			// Kept by `WithStructuredErrors` to be encoded as its JSON encoding.
+			return encodeMsgpackValue(b, v)
+		}
+		writeMsgpackString(b, v.Error())
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(b, len(keys), 0x80, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpackString(b, k)
+			if err := encodeMsgpack(b, v[k]); err != nil {
+				return err
+			}
+		}
+	case logrus.Fields:
+		return encodeMsgpack(b, map[string]interface{}(v))
+	case []interface{}:
+		writeMsgpackHeader(b, len(v), 0x90, 0, 0xdc, 0xdd)
+		for _, e := range v {
+			if err := encodeMsgpack(b, e); err != nil {
+				return err
+			}
+		}
+	default:
+		return encodeMsgpackValue(b, v)
+	}
+	return nil
+}
+
+// encodeMsgpackValue writes the MessagePack encoding of the maps with string keys and the slices
+// of any type, and of the other values through their JSON encoding.
+func encodeMsgpackValue(b *bytes.Buffer, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if _, ok := v.(json.Marshaler); !ok {
+		switch {
+		case rv.Kind() == reflect.Map && rv.Type().Key().Kind() == reflect.String:
+			if rv.IsNil() {
+				return encodeMsgpack(b, nil)
+			}
+			m := make(map[string]interface{}, rv.Len())
+			for _, k := range rv.MapKeys() {
+				m[k.String()] = rv.MapIndex(k).Interface()
+			}
+			return encodeMsgpack(b, m)
+		case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+			if rv.Kind() == reflect.Slice && rv.IsNil() {
+				return encodeMsgpack(b, nil)
+			}
+			s := make([]interface{}, rv.Len())
+			for i := range s {
+				s[i] = rv.Index(i).Interface()
+			}
+			return encodeMsgpack(b, s)
+		}
+	}
+
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(p))
+	d.UseNumber()
+	var decoded interface{}
+	if err := d.Decode(&decoded); err != nil {
+		return err
+	}
+	return encodeMsgpack(b, decoded)
+}
+
+// writeMsgpackHeader writes the header of a string, binary, array or map of `n` elements:
+// `fix` with `n` when it is under the fixed size limit and `fix` is not 0,
+// otherwise the 8, 16 or 32-bit header. A 0 header is not used.
+func writeMsgpackHeader(b *bytes.Buffer, n int, fix, h8, h16, h32 byte) {
+	limit := 16
+	if fix == 0xa0 {
+		limit = 32
+	}
+	switch {
+	case fix != 0 && n < limit:
+		b.WriteByte(fix | byte(n))
+	case h8 != 0 && n <= math.MaxUint8:
+		b.WriteByte(h8)
+		b.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		b.WriteByte(h16)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(h32)
+		binary.Write(b, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgpackString(b *bytes.Buffer, s string) {
+	writeMsgpackHeader(b, len(s), 0xa0, 0xd9, 0xda, 0xdb)
+	b.WriteString(s)
+}
+
+func writeMsgpackInt(b *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0:
+		writeMsgpackUint(b, uint64(i))
+	case i >= -32:
+		b.WriteByte(byte(i))
+	case i >= math.MinInt8:
+		b.WriteByte(0xd0)
+		b.WriteByte(byte(i))
+	case i >= math.MinInt16:
+		b.WriteByte(0xd1)
+		binary.Write(b, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		b.WriteByte(0xd2)
+		binary.Write(b, binary.BigEndian, int32(i))
+	default:
+		b.WriteByte(0xd3)
+		binary.Write(b, binary.BigEndian, i)
+	}
+}
+
+func writeMsgpackUint(b *bytes.Buffer, u uint64) {
+	switch {
+	case u <= 0x7f:
+		b.WriteByte(byte(u))
+	case u <= math.MaxUint8:
+		b.WriteByte(0xcc)
+		b.WriteByte(byte(u))
+	case u <= math.MaxUint16:
+		b.WriteByte(0xcd)
+		binary.Write(b, binary.BigEndian, uint16(u))
+	case u <= math.MaxUint32:
+		b.WriteByte(0xce)
+		binary.Write(b, binary.BigEndian, uint32(u))
+	default:
+		b.WriteByte(0xcf)
+		binary.Write(b, binary.BigEndian, u)
+	}
+}
+
+// writeMsgpackTime writes `t` with the timestamp extension type (-1) in its smallest format.
+func writeMsgpackTime(b *bytes.Buffer, t time.Time) {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		b.Write([]byte{0xd6, 0xff})
+		binary.Write(b, binary.BigEndian, uint32(sec))
+	case sec>>34 == 0:
+		b.Write([]byte{0xd7, 0xff})
+		binary.Write(b, binary.BigEndian, uint64(nsec)<<34|uint64(sec))
+	default:
+		b.Write([]byte{0xc7, 12, 0xff})
+		binary.Write(b, binary.BigEndian, uint32(nsec))
+		binary.Write(b, binary.BigEndian, sec)
+	}
+}
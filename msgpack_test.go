@@ -0,0 +1,85 @@
+package logrustash
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEncodeMsgpack(t *testing.T) {
+	type point struct {
+		X int `json:"x"`
+	}
+
+	testData := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{0xc0}},
+		{true, []byte{0xc3}},
+		{false, []byte{0xc2}},
+		{7, []byte{0x07}},
+		{-5, []byte{0xfb}},
+		{200, []byte{0xcc, 0xc8}},
+		{-200, []byte{0xd1, 0xff, 0x38}},
+		{int64(math.MaxUint32 + 1), []byte{0xcf, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{1.5, []byte{0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0}},
+		{"abc", []byte{0xa3, 'a', 'b', 'c'}},
+		{strings.Repeat("a", 40), append([]byte{0xd9, 40}, strings.Repeat("a", 40)...)},
+		{[]byte{1, 2}, []byte{0xc4, 2, 1, 2}},
+		{[]int{1, 2}, []byte{0x92, 0x01, 0x02}},
+		{map[string]int{"b": 2, "a": 1}, []byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x02}},
+		{point{X: 3}, []byte{0x81, 0xa1, 'x', 0x03}},
+		{time.Unix(1, 0), []byte{0xd6, 0xff, 0, 0, 0, 1}},
+		{time.Unix(1, 1), []byte{0xd7, 0xff, 0, 0, 0, 0x04, 0, 0, 0, 0x01}},
+		{time.Unix(-1, 0), []byte{0xc7, 12, 0xff, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+
+	for _, test := range testData {
+		b := &bytes.Buffer{}
+		if err := encodeMsgpack(b, test.value); err != nil {
+			t.Errorf("expected encodeMsgpack(%#v) to not return error: %s", test.value, err)
+			continue
+		}
+		if !bytes.Equal(b.Bytes(), test.expected) {
+			t.Errorf("expected encodeMsgpack(%#v) to return % x but got % x", test.value, test.expected, b.Bytes())
+		}
+	}
+}
+
+func TestMessagePackFormatter(t *testing.T) {
+	formatter := NewMessagePackFormatter(FormatterConfig{Type: "app"})
+
+	res, err := formatter.Format(&logrus.Entry{
+		Time:    time.Unix(1, 0),
+		Message: "hi",
+		Level:   logrus.InfoLevel,
+		Data:    logrus.Fields{"n": map[string]interface{}{"a": 1}},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	b := &bytes.Buffer{}
+	b.WriteByte(0x86)
+	for _, kv := range [][2]interface{}{{"@timestamp", time.Unix(1, 0)}, {"@version", "1"}, {"level", "info"},
+		{"message", "hi"}, {"n", map[string]interface{}{"a": 1}}, {"type", "app"}} {
+		encodeMsgpack(b, kv[0])
+		encodeMsgpack(b, kv[1])
+	}
+	if !bytes.Equal(res, b.Bytes()) {
+		t.Errorf("expected % x but got % x", b.Bytes(), res)
+	}
+
+	formatter = NewMessagePackFormatter(FormatterConfig{TimestampFormat: TimestampEpochMillis})
+	res, err = formatter.Format(&logrus.Entry{Time: time.Unix(1, 0), Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if !bytes.Contains(res, []byte{0xaa, '@', 't', 'i', 'm', 'e', 's', 't', 'a', 'm', 'p', 0xcd, 0x03, 0xe8}) {
+		t.Errorf("expected the timestamp to be encoded as 1000 milliseconds in % x", res)
+	}
+}
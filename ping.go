@@ -0,0 +1,75 @@
+package logrustash
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// pingTimeout is how long a ping waits for the peer to close the connection.
+const pingTimeout = 10 * time.Millisecond
+
+// pinger is implemented by the writers which can check their connection.
+type pinger interface {
+	Ping() error
+}
+
+// Ping checks that the connection of the hook to Logstash is usable without sending an entry,
+// e.g. for a readiness probe. The connection of the hooks created by `NewHookWithReconnect`
+// and the like is redialed if it is broken, and the error is returned only if redialing fails.
+// The hooks created by `NewFailoverHook` and `NewBalancedHook` fail only if none of their
+// connections is usable. Ping returns nil if the hook does not write to a connection.
+//
+// Note: a broken connection is detected when the peer closed it or refused it (e.g. an ICMP error
+// for UDP), but not when the peer is unreachable, like a write wouldn't.
+func (h *Hook) Ping() error {
+	var errs []error
+	for _, w := range h.writers() {
+		errs = append(errs, ping(w))
+	}
+	// A broken connection may have been redialed.
+	h.sendStartupMarker(h.queue != nil)
+	return joinErrors(errs...)
+}
+
+// ping checks the connection of `w`, if it has one.
+func ping(w io.Writer) error {
+	switch w := w.(type) {
+	case pinger:
+		return w.Ping()
+	case net.Conn:
+		return checkConn(w)
+	}
+	return nil
+}
+
+// checkConn checks that `c` was not closed by the peer with a short read, since Logstash never
+// sends data to its clients.
+func checkConn(c net.Conn) error {
+	if err := c.SetReadDeadline(time.Now().Add(pingTimeout)); err != nil {
+		return err
+	}
+	defer c.SetReadDeadline(time.Time{})
+
+	var b [1]byte
+	_, err := c.Read(b[:])
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return nil
+	}
+	return err
+}
+
+// pingAny pings all the connections so the broken ones are redialed,
+// and returns nil if any of them is usable.
+func pingAny(conns []*conn) error {
+	errs := make([]error, 0, len(conns))
+	for _, c := range conns {
+		if err := c.Ping(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) < len(conns) {
+		return nil
+	}
+	return joinErrors(errs...)
+}
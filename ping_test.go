@@ -0,0 +1,75 @@
+package logrustash
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHookPing(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("expected Dial to not return error: %s", err)
+	}
+	plain := New(c, simpleFmter{})
+	defer plain.Close()
+	h, err := NewHookWithReconnect("tcp", l.Addr().String(), simpleFmter{}, WithReconnectBackoff(0))
+	if err != nil {
+		t.Fatalf("expected NewHookWithReconnect to not return error: %s", err)
+	}
+	defer h.Close()
+
+	if err := plain.Ping(); err != nil {
+		t.Errorf("expected Ping to not return error: %s", err)
+	}
+	if err := h.Ping(); err != nil {
+		t.Errorf("expected Ping to not return error: %s", err)
+	}
+
+	// The server closes both connections.
+	for i := 0; i < 2; i++ {
+		select {
+		case sc := <-accepted:
+			sc.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected the connections to be accepted")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := plain.Ping(); err == nil {
+		t.Error("expected Ping to return error once the connection is closed by the server")
+	}
+	if err := h.Ping(); err != nil {
+		t.Errorf("expected Ping to redial the connection but got %s", err)
+	}
+	select {
+	case sc := <-accepted:
+		sc.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("expected Ping to redial the connection")
+	}
+}
+
+func TestHookPingWithoutConn(t *testing.T) {
+	if err := New(bytes.NewBuffer(nil), simpleFmter{}).Ping(); err != nil {
+		t.Errorf("expected Ping to not return error for a writer which is not a connection: %s", err)
+	}
+}
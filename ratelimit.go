@@ -0,0 +1,161 @@
+package logrustash
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithRateLimit limits the entries sent to Logstash to `perSecond` entries per second
+// with bursts of up to `burst` entries. The entries above the limit are dropped and
+// counted in `Stats().Dropped`. See `WithSuppressedSummary` to report them to Logstash.
+func WithRateLimit(perSecond int, burst int) HookOption {
+	return func(h *Hook) {
+		h.limiter = newRateLimiter(perSecond, burst)
+	}
+}
+
+// WithSuppressedSummary makes a hook with `WithRateLimit` send a warning entry with the message
+// "N messages suppressed" before the first entry allowed after entries were dropped by the rate limit.
+// Like the other entries, the summary is sent only if the levels of the hook include `logrus.WarnLevel`.
+func WithSuppressedSummary() HookOption {
+	return func(h *Hook) {
+		h.summarize = true
+	}
+}
+
+// rateLimiter is a token bucket which is safe for concurrent use.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu         sync.Mutex
+	tokens     float64
+	last       time.Time
+	suppressed int
+}
+
+func newRateLimiter(perSecond int, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   float64(perSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// ready adds the tokens earned until `now` and reports whether a token can be taken.
+// If not, the entry is counted as suppressed. It must be called with `mu` held.
+func (l *rateLimiter) ready(now time.Time) bool {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		l.suppressed++
+		return false
+	}
+	return true
+}
+
+// take takes a token and returns how many entries were suppressed since the last allowed one.
+// It must be called with `mu` held, after `ready` returned true.
+func (l *rateLimiter) take() int {
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return suppressed
+}
+
+// WithByteBudget limits the bytes sent to Logstash to `bytesPerSecond` bytes in any one-second window,
+// e.g. to cap the cost of a Logstash instance which is billed by volume during a storm of entries.
+// The size of an entry is the size of its formatted and framed bytes. The entries which would exceed
+// the budget are dropped and counted in `Stats().Dropped`. With `WithRateLimit`, the entries must be
+// allowed by both limits, so the most restrictive one applies, and an entry dropped by one limit
+// doesn't use up the other.
+func WithByteBudget(bytesPerSecond int) HookOption {
+	return func(h *Hook) {
+		h.budget = &byteBudget{limit: bytesPerSecond}
+	}
+}
+
+// byteSample is the size of an entry allowed by a byteBudget and the time it was allowed.
+type byteSample struct {
+	at   time.Time
+	size int
+}
+
+// byteBudget is a sliding window of the sizes of the entries which were sent in the last second.
+// It is safe for concurrent use.
+type byteBudget struct {
+	limit int
+
+	mu      sync.Mutex
+	samples []byteSample
+	total   int
+}
+
+// fits reports whether `size` bytes can be sent at `now`. It must be called with `mu` held.
+func (b *byteBudget) fits(now time.Time, size int) bool {
+	start := now.Add(-time.Second)
+	i := 0
+	for ; i < len(b.samples) && !b.samples[i].at.After(start); i++ {
+		b.total -= b.samples[i].size
+	}
+	b.samples = b.samples[i:]
+	return b.total+size <= b.limit
+}
+
+// add counts `size` bytes sent at `now`. It must be called with `mu` held, after `fits` returned true.
+func (b *byteBudget) add(now time.Time, size int) {
+	b.samples = append(b.samples, byteSample{at: now, size: size})
+	b.total += size
+}
+
+// admit reports whether an entry of `size` bytes can be sent at `now`: it must fit in the byte budget
+// and, if `limited`, get a token of the rate limit. The token is taken and the bytes are counted only
+// when both limits allow the entry, so an entry dropped by one limit doesn't use up the other.
+// It also returns how many entries the rate limit suppressed since the last allowed one.
+func (h *Hook) admit(now time.Time, size int, limited bool) (bool, int) {
+	l, b := h.limiter, h.budget
+	if !limited {
+		l = nil
+	}
+	if l != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !l.ready(now) {
+			return false, 0
+		}
+	}
+	if b != nil {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if !b.fits(now, size) {
+			return false, 0
+		}
+		b.add(now, size)
+	}
+	if l == nil {
+		return true, 0
+	}
+	return true, l.take()
+}
+
+// suppressedEntry returns the entry which reports that `n` entries were suppressed before `e`.
+func suppressedEntry(e *logrus.Entry, n int) *logrus.Entry {
+	return &logrus.Entry{
+		Logger:  e.Logger,
+		Data:    logrus.Fields{},
+		Time:    e.Time,
+		Level:   logrus.WarnLevel,
+		Message: fmt.Sprintf("%d messages suppressed", n),
+	}
+}
@@ -0,0 +1,146 @@
+package logrustash
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRateLimiter(t *testing.T) {
+	h := &Hook{limiter: newRateLimiter(10, 2)}
+	now := time.Now()
+
+	for i, expected := range []bool{true, true, false, false} {
+		if allowed, _ := h.admit(now, 0, true); allowed != expected {
+			t.Errorf("expected allow #%d to return %v", i, expected)
+		}
+	}
+	// 100ms later one token is available and the two suppressed entries are reported.
+	allowed, suppressed := h.admit(now.Add(100*time.Millisecond), 0, true)
+	if !allowed || suppressed != 2 {
+		t.Errorf("expected allow to report 2 suppressed entries but got %v, %d", allowed, suppressed)
+	}
+	// The bucket never holds more than `burst` tokens.
+	now = now.Add(time.Hour)
+	for i, expected := range []bool{true, true, false} {
+		if allowed, _ := h.admit(now, 0, true); allowed != expected {
+			t.Errorf("expected allow #%d after an hour to return %v", i, expected)
+		}
+	}
+}
+
+func TestHookWithRateLimit(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := New(buffer, simpleFmter{}, WithRateLimit(1, 1), WithSuppressedSummary())
+	for _, msg := range []string{"a", "b", "c"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if buffer.String() != `msg: "a"` {
+		t.Errorf("expected only the first entry to be written but got '%s'", buffer.String())
+	}
+	if h.Stats().Dropped != 2 {
+		t.Errorf("expected 2 dropped entries but got %d", h.Stats().Dropped)
+	}
+
+	h.limiter.last = h.limiter.last.Add(-time.Second)
+	if err := h.Fire(&logrus.Entry{Message: "d", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	expected := `msg: "a"msg: "2 messages suppressed"msg: "d"`
+	if buffer.String() != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, buffer.String())
+	}
+}
+
+func TestHookWithRateLimitWithoutSummary(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		opts   []HookOption
+		levels []logrus.Level
+	}{
+		{"no summary", []HookOption{WithRateLimit(1, 1)}, logrus.AllLevels},
+		{"warn level disabled", []HookOption{WithRateLimit(1, 1), WithSuppressedSummary()}, []logrus.Level{logrus.ErrorLevel}},
+	} {
+		buffer := bytes.NewBuffer(nil)
+		h := New(buffer, simpleFmter{}, test.opts...)
+		h.SetLevels(test.levels)
+		for _, msg := range []string{"a", "b"} {
+			if err := h.Fire(&logrus.Entry{Message: msg, Level: logrus.ErrorLevel, Data: logrus.Fields{}}); err != nil {
+				t.Fatalf("%s: expected Fire to not return error: %s", test.name, err)
+			}
+		}
+		h.limiter.last = h.limiter.last.Add(-time.Second)
+		if err := h.Fire(&logrus.Entry{Message: "c", Level: logrus.ErrorLevel, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("%s: expected Fire to not return error: %s", test.name, err)
+		}
+		if buffer.String() != `msg: "a"msg: "c"` {
+			t.Errorf("%s: expected no summary but got '%s'", test.name, buffer.String())
+		}
+	}
+}
+
+func TestByteBudget(t *testing.T) {
+	h := &Hook{budget: &byteBudget{limit: 100}}
+	now := time.Now()
+
+	for i, test := range []struct {
+		at       time.Duration
+		size     int
+		expected bool
+	}{
+		{0, 40, true},
+		{100 * time.Millisecond, 40, true},
+		{200 * time.Millisecond, 40, false},
+		{300 * time.Millisecond, 20, true},
+		{500 * time.Millisecond, 200, false},
+		// The first entry left the window.
+		{time.Second, 40, true},
+		{1100 * time.Millisecond, 40, true},
+		{1150 * time.Millisecond, 10, false},
+	} {
+		if allowed, _ := h.admit(now.Add(test.at), test.size, true); allowed != test.expected {
+			t.Errorf("expected allow #%d to return %v", i, test.expected)
+		}
+	}
+}
+
+func TestHookWithByteBudget(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	// Every entry is 10 bytes long.
+	h := New(buffer, simpleFmter{}, WithByteBudget(25), WithRateLimit(100, 100))
+	for _, msg := range []string{"aa", "bb", "cc", "a very long message"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	if buffer.String() != `msg: "aa"msg: "bb"` {
+		t.Errorf("expected only the entries within the budget to be written but got '%s'", buffer.String())
+	}
+	if h.Stats().Dropped != 2 {
+		t.Errorf("expected 2 dropped entries but got %d", h.Stats().Dropped)
+	}
+}
+
+func TestHookWithByteBudgetKeepsRateLimitTokens(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	// Every entry is 10 bytes long, so the budget allows one.
+	h := New(buffer, simpleFmter{}, WithByteBudget(15), WithRateLimit(0, 2))
+	for _, msg := range []string{"aa", "bb"} {
+		if err := h.Fire(&logrus.Entry{Message: msg, Data: logrus.Fields{}}); err != nil {
+			t.Fatalf("expected Fire to not return error: %s", err)
+		}
+	}
+	// No token is ever added, but the entry dropped by the budget didn't take the second one,
+	// so the next entry is sent once the budget allows it.
+	h.budget.samples[0].at = h.budget.samples[0].at.Add(-time.Second)
+	if err := h.Fire(&logrus.Entry{Message: "cc", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if buffer.String() != `msg: "aa"msg: "cc"` {
+		t.Errorf("expected the entry dropped by the budget to not use a token but got '%s'", buffer.String())
+	}
+}
@@ -0,0 +1,61 @@
+package logrustash
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrReconnectNotSupported is returned by `Reconnect` when the hook does not dial its connections.
+var ErrReconnectNotSupported = errors.New("logrustash: reconnect is not supported by the hook's writer")
+
+// reconnecter is implemented by the writers which can dial their connection again.
+type reconnecter interface {
+	Reconnect() error
+}
+
+// Reconnect closes the connection of the hook to Logstash and dials a new one, e.g. after the
+// address of Logstash changed in the DNS, and returns the dial error, if any. It is supported by
+// the hooks created by `NewHookWithReconnect`, `NewFailoverHook`, `NewBalancedHook` and the like,
+// which dial their connections, and it returns `ErrReconnectNotSupported` for the other hooks.
+// The reconnect backoff (see `WithReconnectBackoff`) does not apply.
+//
+// It is safe to call Reconnect while entries are fired: they are written to the new connection.
+func (h *Hook) Reconnect() error {
+	err := reconnectAll(h.writers())
+	h.sendStartupMarker(h.queue != nil)
+	return err
+}
+
+// reconnect dials the connection of `w` again.
+func reconnect(w io.Writer) error {
+	if r, ok := w.(reconnecter); ok {
+		return r.Reconnect()
+	}
+	return ErrReconnectNotSupported
+}
+
+// reconnectAll dials the connections of the writers in `ws` which support it again,
+// or returns `ErrReconnectNotSupported` if none of them does.
+func reconnectAll(ws []io.Writer) error {
+	var errs []error
+	supported := false
+	for _, w := range ws {
+		if _, ok := w.(reconnecter); ok {
+			supported = true
+			errs = append(errs, reconnect(w))
+		}
+	}
+	if !supported {
+		return ErrReconnectNotSupported
+	}
+	return joinErrors(errs...)
+}
+
+// reconnectConns dials all the connections in `conns` again.
+func reconnectConns(conns []*conn) error {
+	errs := make([]error, len(conns))
+	for i, c := range conns {
+		errs[i] = c.Reconnect()
+	}
+	return joinErrors(errs...)
+}
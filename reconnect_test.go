@@ -0,0 +1,71 @@
+package logrustash
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHookReconnect(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected Listen to not return error: %s", err)
+	}
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	// The default backoff doesn't delay the reconnection.
+	h, err := NewHookWithReconnect("tcp", l.Addr().String(), simpleFmter{})
+	if err != nil {
+		t.Fatalf("expected NewHookWithReconnect to not return error: %s", err)
+	}
+	defer h.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				h.Fire(&logrus.Entry{Message: "hello"})
+			}
+		}()
+	}
+	if err := h.Reconnect(); err != nil {
+		t.Errorf("expected Reconnect to not return error: %s", err)
+	}
+	wg.Wait()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case sc := <-accepted:
+			sc.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected Reconnect to dial a new connection")
+		}
+	}
+
+	l.Close()
+	if err := h.Reconnect(); err == nil {
+		t.Error("expected Reconnect to return the dial error")
+	}
+}
+
+func TestHookReconnectNotSupported(t *testing.T) {
+	h := New(bytes.NewBuffer(nil), simpleFmter{}, WithBufferedWriter(16, 0))
+	if err := h.Reconnect(); err != ErrReconnectNotSupported {
+		t.Errorf("expected Reconnect to return ErrReconnectNotSupported but got %v", err)
+	}
+}
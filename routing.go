@@ -0,0 +1,124 @@
+package logrustash
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewHookWithLevelWriters returns a new logrus.Hook for Logstash which writes the entries
+// to the writer of their level in `writers`, or to `defaultWriter` for the levels which are not in it,
+// e.g. to send the errors to a dedicated Logstash input.
+// The formatter, levels and options are shared by all the writers.
+// An entry of a level with no writer and no `defaultWriter` is not written and `Fire` returns an error.
+//
+// hook := logrustash.NewHookWithLevelWriters(map[logrus.Level]io.Writer{logrus.ErrorLevel: errConn}, conn, logrustash.DefaultFormatter(logrus.Fields{}))
+func NewHookWithLevelWriters(writers map[logrus.Level]io.Writer, defaultWriter io.Writer, f logrus.Formatter, opts ...HookOption) *Hook {
+	h := newHook(f, opts...)
+	h.levelWriters = make(map[logrus.Level]io.Writer, len(writers))
+	for level, w := range writers {
+		if w != nil {
+			h.levelWriters[level] = h.wrapWriter(w)
+		}
+	}
+	h.setWriter(h.wrapWriter(defaultWriter))
+	return h
+}
+
+// SinkKey is the key of the entry field which sends that entry to the writer registered
+// under its value by `RegisterWriter` instead of the writers of the hook, e.g. for the audit entries:
+//
+// log.WithField(logrustash.SinkKey, "audit").Info("user deleted")
+//
+// The field is removed from the entries which are sent. Its key is set by `WithSinkKey`.
+const SinkKey = "_sink"
+
+// WithSinkKey sets the key of the entry field which selects the writer of an entry. See `SinkKey`.
+// An empty key disables the field.
+func WithSinkKey(key string) HookOption {
+	return func(h *Hook) {
+		h.sinkKey = key
+	}
+}
+
+// RegisterWriter registers `w` as the writer of the entries whose `SinkKey` field is `name`.
+// The entries without the field are written to the writers of the hook, and `Fire` returns an error
+// for the entries whose field is a name with no registered writer. The writer is wrapped like the
+// writers of the hook, e.g. by `WithBufferedWriter`, and it is flushed and closed with them.
+// A writer registered under a name which is already registered replaces the previous one, which is not closed.
+//
+// It is safe to call RegisterWriter while entries are fired.
+func (h *Hook) RegisterWriter(name string, w io.Writer) {
+	w = h.wrapWriter(w)
+
+	h.sinksMu.Lock()
+	defer h.sinksMu.Unlock()
+
+	sinks := make(map[string]io.Writer, len(h.sinks)+1)
+	for k, v := range h.sinks {
+		sinks[k] = v
+	}
+	sinks[name] = w
+	h.sinks = sinks
+}
+
+// registeredWriters returns the writers registered by `RegisterWriter`.
+func (h *Hook) registeredWriters() map[string]io.Writer {
+	h.sinksMu.RLock()
+	defer h.sinksMu.RUnlock()
+
+	return h.sinks
+}
+
+// writerFor returns the writer registered as `sink`, or the writer of the entries of `level` if `sink` is empty.
+func (h *Hook) writerFor(level logrus.Level, sink string) (io.Writer, error) {
+	if sink != "" {
+		if w, ok := h.registeredWriters()[sink]; ok {
+			return w, nil
+		}
+		return nil, fmt.Errorf("logrustash: no writer registered as %q", sink)
+	}
+	if w, ok := h.levelWriters[level]; ok {
+		return w, nil
+	}
+	if h.writer == nil && h.levelWriters != nil {
+		return nil, fmt.Errorf("logrustash: no writer for the %s level", level)
+	}
+	if h.writer == nil {
+		return nil, ErrNoWriter
+	}
+	return h.writer, nil
+}
+
+// writers returns the distinct writers of the hook, the default writer first.
+func (h *Hook) writers() []io.Writer {
+	var ws []io.Writer
+	if h.writer != nil {
+		ws = append(ws, h.writer)
+	}
+	others := make([]io.Writer, 0, len(h.levelWriters))
+	for _, w := range h.levelWriters {
+		others = append(others, w)
+	}
+	for _, w := range h.registeredWriters() {
+		others = append(others, w)
+	}
+	for _, w := range others {
+		found := false
+		// Comparing the writers panics if they are of the same uncomparable type, like WriterFunc,
+		// so those are never deduplicated.
+		comparable := reflect.TypeOf(w).Comparable()
+		for _, o := range ws {
+			if comparable && o == w {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
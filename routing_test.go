@@ -0,0 +1,128 @@
+package logrustash
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHookWithLevelWriters(t *testing.T) {
+	errBuf, defaultBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	h := NewHookWithLevelWriters(map[logrus.Level]io.Writer{logrus.ErrorLevel: errBuf}, defaultBuf, &simpleFmter{})
+
+	if err := h.Fire(&logrus.Entry{Message: "failed", Level: logrus.ErrorLevel}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if err := h.Fire(&logrus.Entry{Message: "started", Level: logrus.InfoLevel}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+
+	if errBuf.String() != `msg: "failed"` {
+		t.Errorf("expected the error writer to get the error entry but got %q", errBuf.String())
+	}
+	if defaultBuf.String() != `msg: "started"` {
+		t.Errorf("expected the default writer to get the info entry but got %q", defaultBuf.String())
+	}
+}
+
+func TestHookWithLevelWritersNoWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := NewHookWithLevelWriters(map[logrus.Level]io.Writer{logrus.ErrorLevel: buf}, nil, &simpleFmter{})
+
+	err := h.Fire(&logrus.Entry{Message: "started", Level: logrus.InfoLevel})
+	if err == nil || !strings.Contains(err.Error(), "no writer for the info level") {
+		t.Errorf("expected a missing writer error but got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written but got %q", buf.String())
+	}
+}
+
+func TestHookWithLevelWritersAsync(t *testing.T) {
+	errConn, defaultConn := &fakeConn{buffer: &bytes.Buffer{}}, &fakeConn{buffer: &bytes.Buffer{}}
+	h := NewAsyncHook(defaultConn, &simpleFmter{}, 10)
+	// The level writers are set like NewHookWithLevelWriters does.
+	h.levelWriters = map[logrus.Level]io.Writer{logrus.ErrorLevel: errConn}
+
+	h.Fire(&logrus.Entry{Message: "failed", Level: logrus.ErrorLevel})
+	h.Fire(&logrus.Entry{Message: "started", Level: logrus.InfoLevel})
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+
+	if errConn.buffer.String() != `msg: "failed"` {
+		t.Errorf("expected the error writer to get the error entry but got %q", errConn.buffer.String())
+	}
+	if defaultConn.buffer.String() != `msg: "started"` {
+		t.Errorf("expected the default writer to get the info entry but got %q", defaultConn.buffer.String())
+	}
+	if !errConn.closed || !defaultConn.closed {
+		t.Errorf("expected Close to close all the writers")
+	}
+}
+
+func TestHookRegisterWriter(t *testing.T) {
+	auditConn, defaultConn := &fakeConn{buffer: &bytes.Buffer{}}, &fakeConn{buffer: &bytes.Buffer{}}
+	h := New(defaultConn, DefaultFormatter(logrus.Fields{}))
+	h.RegisterWriter("audit", auditConn)
+
+	if err := h.Fire(&logrus.Entry{Message: "user deleted", Data: logrus.Fields{SinkKey: "audit"}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+	if err := h.Fire(&logrus.Entry{Message: "started", Data: logrus.Fields{}}); err != nil {
+		t.Fatalf("expected Fire to not return error: %s", err)
+	}
+
+	if !strings.Contains(auditConn.buffer.String(), "user deleted") || strings.Contains(auditConn.buffer.String(), SinkKey) {
+		t.Errorf("expected the audit writer to get the audit entry without the sink field but got %q", auditConn.buffer.String())
+	}
+	if !strings.Contains(defaultConn.buffer.String(), "started") || strings.Contains(defaultConn.buffer.String(), "user deleted") {
+		t.Errorf("expected the default writer to get the other entry only but got %q", defaultConn.buffer.String())
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("expected Close to not return error: %s", err)
+	}
+	if !auditConn.closed {
+		t.Error("expected Close to close the registered writer")
+	}
+}
+
+func TestHookWritersOfUncomparableType(t *testing.T) {
+	discard := func(p []byte) (int, error) { return len(p), nil }
+	h := New(WriterFunc(discard), simpleFmter{})
+	h.RegisterWriter("audit", WriterFunc(discard))
+
+	if ws := h.writers(); len(ws) != 2 {
+		t.Errorf("expected both writers to be kept but got %d", len(ws))
+	}
+	if err := h.Flush(time.Second); err != nil {
+		t.Errorf("expected Flush to not return error: %s", err)
+	}
+	if err := h.Ping(); err != nil {
+		t.Errorf("expected Ping to not return error: %s", err)
+	}
+	if err := h.Reconnect(); err != ErrReconnectNotSupported {
+		t.Errorf("expected Reconnect to not be supported but got %v", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+}
+
+func TestHookRegisterWriterUnknownSink(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := New(buf, &simpleFmter{}, WithSinkKey("sink"))
+
+	err := h.Fire(&logrus.Entry{Message: "user deleted", Data: logrus.Fields{"sink": "audit"}})
+	if err == nil || !strings.Contains(err.Error(), `no writer registered as "audit"`) {
+		t.Errorf("expected a missing writer error but got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written but got %q", buf.String())
+	}
+}
@@ -0,0 +1,36 @@
+package logrustash
+
+import (
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WithSampling sends only one of every `rate` entries at `level` to Logstash, starting with the first one.
+// The other entries at that level are dropped and counted in `Stats().Dropped`.
+// Unlike removing the level, it keeps some visibility of high-volume levels like debug.
+// It can be passed once for every level to sample.
+func WithSampling(level logrus.Level, rate int) HookOption {
+	return func(h *Hook) {
+		if rate <= 1 {
+			delete(h.samplers, level)
+			return
+		}
+		if h.samplers == nil {
+			h.samplers = map[logrus.Level]*sampler{}
+		}
+		h.samplers[level] = &sampler{rate: uint64(rate)}
+	}
+}
+
+// sampler lets one of every `rate` entries through. It is safe for concurrent use.
+type sampler struct {
+	// count is accessed atomically and must stay the first field to be 64-bit aligned.
+	count uint64
+	rate  uint64
+}
+
+// sample reports whether the next entry is let through.
+func (s *sampler) sample() bool {
+	return (atomic.AddUint64(&s.count, 1)-1)%s.rate == 0
+}
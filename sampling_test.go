@@ -0,0 +1,60 @@
+package logrustash
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHookWithSampling(t *testing.T) {
+	buffer := &recordWriter{}
+	h := New(buffer, simpleFmter{}, WithSampling(logrus.DebugLevel, 10))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				h.Fire(&logrus.Entry{Level: logrus.DebugLevel, Message: "debug", Data: logrus.Fields{}})
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 5; i++ {
+		h.Fire(&logrus.Entry{Level: logrus.WarnLevel, Message: "warn", Data: logrus.Fields{}})
+	}
+
+	var debug, warn int
+	for _, w := range buffer.Writes() {
+		switch w {
+		case `msg: "debug"`:
+			debug++
+		case `msg: "warn"`:
+			warn++
+		}
+	}
+	if debug != 10 {
+		t.Errorf("expected 10 of the 100 debug entries to be written but got %d", debug)
+	}
+	if warn != 5 {
+		t.Errorf("expected all the 5 warning entries to be written but got %d", warn)
+	}
+	if h.Stats().Dropped != 90 {
+		t.Errorf("expected 90 dropped entries but got %d", h.Stats().Dropped)
+	}
+}
+
+func TestHookWithSamplingRateOne(t *testing.T) {
+	buffer := bytes.NewBuffer(nil)
+	h := New(buffer, simpleFmter{}, WithSampling(logrus.InfoLevel, 10), WithSampling(logrus.InfoLevel, 1))
+
+	for i := 0; i < 3; i++ {
+		h.Fire(&logrus.Entry{Level: logrus.InfoLevel, Message: "info", Data: logrus.Fields{}})
+	}
+	if buffer.String() != `msg: "info"msg: "info"msg: "info"` {
+		t.Errorf("expected a rate of 1 to write every entry but got '%s'", buffer.String())
+	}
+}
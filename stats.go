@@ -0,0 +1,44 @@
+package logrustash
+
+import "sync/atomic"
+
+// HookStats is a snapshot of the counters of a hook. See `Hook.Stats`.
+type HookStats struct {
+	// Sent is the number of entries written successfully.
+	Sent uint64
+	// Dropped is the number of entries dropped without being written, e.g. because the queue was full.
+	Dropped uint64
+	// Failed is the number of entries whose write failed, after all the retries.
+	Failed uint64
+	// Retried is the number of write attempts retried after a failure. See `WithRetry`.
+	Retried uint64
+}
+
+// hookStats holds the counters of a hook. They are accessed atomically.
+type hookStats struct {
+	sent    uint64
+	dropped uint64
+	failed  uint64
+	retried uint64
+}
+
+// written counts `n` entries as sent if `err` is nil or as failed otherwise.
+func (s *hookStats) written(n int, err error) {
+	if err != nil {
+		atomic.AddUint64(&s.failed, uint64(n))
+		return
+	}
+	atomic.AddUint64(&s.sent, uint64(n))
+}
+
+// Stats returns a snapshot of the hook's counters.
+// It is safe to call while entries are fired. For asynchronous and batch hooks,
+// an entry is counted as sent or failed only once it is actually written by the background worker.
+func (h *Hook) Stats() HookStats {
+	return HookStats{
+		Sent:    atomic.LoadUint64(&h.stats.sent),
+		Dropped: atomic.LoadUint64(&h.stats.dropped),
+		Failed:  atomic.LoadUint64(&h.stats.failed),
+		Retried: atomic.LoadUint64(&h.stats.retried),
+	}
+}
@@ -0,0 +1,63 @@
+package logrustash
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestStats(t *testing.T) {
+	w := &flakyWriter{failures: 3}
+	h := New(w, simpleFmter{}, WithRetry(2, time.Millisecond))
+
+	// The first entry fails twice, the second fails once and then succeeds.
+	for i := 0; i < 3; i++ {
+		h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+	}
+
+	expected := HookStats{Sent: 2, Failed: 1, Retried: 2}
+	if s := h.Stats(); s != expected {
+		t.Errorf("expected stats to be %+v but got %+v", expected, s)
+	}
+}
+
+func TestStatsBatchHook(t *testing.T) {
+	h := NewBatchHook(bytes.NewBuffer(nil), simpleFmter{}, 1024, time.Hour)
+	for i := 0; i < 3; i++ {
+		h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+	}
+	if s := h.Stats(); s.Sent != 0 {
+		t.Errorf("expected no sent entries before the batch is written but got %+v", s)
+	}
+
+	h.Close()
+	expected := HookStats{Sent: 3}
+	if s := h.Stats(); s != expected {
+		t.Errorf("expected stats to be %+v but got %+v", expected, s)
+	}
+}
+
+func TestStatsConcurrentAsyncHook(t *testing.T) {
+	h := NewAsyncHook(bytes.NewBuffer(nil), simpleFmter{}, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				h.Fire(&logrus.Entry{Message: "msg", Data: logrus.Fields{}})
+				h.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+	h.Close()
+
+	if s := h.Stats(); s.Sent+s.Dropped != 100 {
+		t.Errorf("expected sent and dropped entries to sum up to 100 but got %+v", s)
+	}
+}
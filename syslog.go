@@ -0,0 +1,147 @@
+package logrustash
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// syslogUserFacility is the syslog facility of user-level messages.
+	syslogUserFacility = 1
+	// syslogSDID is the default SD-ID of the structured data element of the entry data.
+	// 32473 is the private enterprise number reserved for documentation by RFC 5612.
+	syslogSDID = "fields@32473"
+	// syslogTimestampFormat is the RFC 5424 timestamp format with microseconds.
+	syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Syslog5424Formatter formats an entry to an RFC 5424 syslog message terminated by a newline,
+// so the hook can send the entries to Logstash through a syslog relay.
+//
+// The priority of the message is computed from `Facility` and the syslog severity of the log level
+// (error is 3, warning is 4, info is 6 and debug is 7) and the fields of the entry data are sent as
+// the parameters of a single structured data element.
+type Syslog5424Formatter struct {
+	// Facility is the syslog facility of the messages. It defaults to 1 (user-level messages).
+	Facility int
+	// Hostname is the HOSTNAME of the messages. It defaults to the machine hostname.
+	Hostname string
+	// AppName is the APP-NAME of the messages. It defaults to the name of the program.
+	AppName string
+	// MsgID is the MSGID of the messages. It is omitted by default.
+	MsgID string
+	// SDID is the SD-ID of the structured data element of the entry data. It defaults to "fields@32473".
+	SDID string
+}
+
+// Format formats an entry to an RFC 5424 syslog message.
+func (f Syslog5424Formatter) Format(e *logrus.Entry) ([]byte, error) {
+	facility := f.Facility
+	if facility == 0 {
+		facility = syslogUserFacility
+	}
+	hostname := f.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	appName := f.AppName
+	if appName == "" {
+		appName = filepath.Base(os.Args[0])
+	}
+	sdID := f.SDID
+	if sdID == "" {
+		sdID = syslogSDID
+	}
+
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "<%d>1 ", facility*8+syslogSeverity(e.Level))
+	if e.Time.IsZero() {
+		b.WriteString("-")
+	} else {
+		b.WriteString(e.Time.Format(syslogTimestampFormat))
+	}
+	for _, h := range []struct {
+		value  string
+		maxLen int
+	}{
+		{hostname, 255},
+		{appName, 48},
+		{strconv.Itoa(os.Getpid()), 128},
+		{f.MsgID, 32},
+	} {
+		b.WriteByte(' ')
+		b.WriteString(syslogHeaderField(h.value, h.maxLen))
+	}
+
+	b.WriteByte(' ')
+	if len(e.Data) == 0 {
+		b.WriteString("-")
+	} else {
+		writeSyslogStructuredData(b, sdID, e.Data)
+	}
+	if e.Message != "" {
+		b.WriteByte(' ')
+		b.WriteString(strings.TrimSuffix(e.Message, "\n"))
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// syslogHeaderField returns `s` as an RFC 5424 header field of at most `maxLen` printable
+// ASCII characters, or "-" when it is empty.
+func syslogHeaderField(s string, maxLen int) string {
+	s = syslogName(s, maxLen)
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogName returns `s` with the characters which are not printable ASCII replaced by underscores,
+// as well as the characters in `forbidden`, truncated to `maxLen` characters.
+func syslogName(s string, maxLen int, forbidden ...rune) string {
+	name := []rune(s)
+	if len(name) > maxLen {
+		name = name[:maxLen]
+	}
+	for i, r := range name {
+		if r < 33 || r > 126 || strings.ContainsRune(string(forbidden), r) {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// writeSyslogStructuredData writes `data` to `b` as an RFC 5424 structured data element with `sdID`.
+func writeSyslogStructuredData(b *bytes.Buffer, sdID string, data logrus.Fields) {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b.WriteByte('[')
+	b.WriteString(syslogName(sdID, 32, '=', ']', '"', ' '))
+	for _, k := range keys {
+		v := data[k]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		b.WriteByte(' ')
+		b.WriteString(syslogName(k, 32, '=', ']', '"', ' '))
+		b.WriteString(`="`)
+		b.WriteString(syslogParamEscaper.Replace(fmt.Sprint(v)))
+		b.WriteByte('"')
+	}
+	b.WriteByte(']')
+}
+
+// syslogParamEscaper escapes the characters of a structured data parameter value as RFC 5424 requires.
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
@@ -0,0 +1,67 @@
+package logrustash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSyslog5424Formatter(t *testing.T) {
+	formatter := Syslog5424Formatter{Hostname: "web1", AppName: "billing", MsgID: "REQ"}
+
+	res, err := formatter.Format(&logrus.Entry{
+		Message: "request failed\n",
+		Level:   logrus.ErrorLevel,
+		Time:    time.Date(2017, 5, 3, 10, 20, 30, 123456789, time.UTC),
+		Data: logrus.Fields{
+			"method":  "GET",
+			"path":    `/a"b]c\d`,
+			"user id": 42,
+			"error":   errors.New("timeout"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := fmt.Sprintf(`<11>1 2017-05-03T10:20:30.123456Z web1 billing %d REQ `+
+		`[fields@32473 error="timeout" method="GET" path="/a\"b\]c\\d" user_id="42"] request failed`+"\n", os.Getpid())
+	if string(res) != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, string(res))
+	}
+}
+
+func TestSyslog5424FormatterWithoutData(t *testing.T) {
+	formatter := Syslog5424Formatter{Facility: 16, Hostname: "web 1", AppName: "billing"}
+
+	res, err := formatter.Format(&logrus.Entry{Level: logrus.InfoLevel, Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := fmt.Sprintf("<134>1 - web_1 billing %d - -\n", os.Getpid())
+	if string(res) != expected {
+		t.Errorf("expected '%s' but got '%s'", expected, string(res))
+	}
+}
+
+func TestSyslog5424FormatterPriority(t *testing.T) {
+	testData := map[logrus.Level]string{
+		logrus.ErrorLevel: "<11>",
+		logrus.WarnLevel:  "<12>",
+		logrus.InfoLevel:  "<14>",
+		logrus.DebugLevel: "<15>",
+	}
+	for level, expected := range testData {
+		res, err := Syslog5424Formatter{}.Format(&logrus.Entry{Level: level, Data: logrus.Fields{}})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.HasPrefix(string(res), expected) {
+			t.Errorf("expected the %s message to start with '%s' but got '%s'", level, expected, string(res))
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package logrustash
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewNopHook returns a new logrus.Hook which discards the entries instead of sending them to Logstash,
+// for the tests of programs which log to Logstash. It never returns an error but it fires
+// only the entries at its levels, like the other hooks.
+func NewNopHook(opts ...HookOption) *Hook {
+	return New(ioutil.Discard, nopFormatter{}, opts...)
+}
+
+// nopFormatter formats every entry to nothing.
+type nopFormatter struct{}
+
+func (nopFormatter) Format(*logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// NewTestHook returns a new logrus.Hook which writes the entries to a CaptureBuffer instead of Logstash,
+// formatted by `DefaultFormatter` with `opts`, so tests can check the entries their program logs:
+//
+// hook, buffer := logrustash.NewTestHook()
+// log.Hooks.Add(hook)
+// log.Info("hello")
+// buffer.Entries()[0]["message"] // "hello"
+func NewTestHook(opts ...FormatterOption) (*Hook, *CaptureBuffer) {
+	buffer := &CaptureBuffer{}
+	return New(buffer, DefaultFormatter(logrus.Fields{}, opts...)), buffer
+}
+
+// CaptureBuffer is an in-memory writer of newline-delimited JSON entries. It is safe for concurrent use.
+// See `NewTestHook`.
+type CaptureBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *CaptureBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+// Entries returns the entries written so far, each decoded from JSON to a map.
+// The lines which are not JSON objects are skipped.
+func (b *CaptureBuffer) Entries() []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	s := bufio.NewScanner(bytes.NewReader(b.buffer.Bytes()))
+	s.Buffer(nil, b.buffer.Len()+1)
+	for s.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &entry); err == nil && entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Reset discards the entries written so far.
+func (b *CaptureBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buffer.Reset()
+}
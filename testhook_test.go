@@ -0,0 +1,57 @@
+package logrustash
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestNopHook(t *testing.T) {
+	h := NewNopHook()
+	h.SetLevel(logrus.InfoLevel)
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.SetLevel(logrus.DebugLevel)
+	log.Hooks.Add(h)
+	log.Info("sent")
+	log.Debug("not sent")
+
+	if err := h.Fire(&logrus.Entry{Level: logrus.ErrorLevel, Data: logrus.Fields{}}); err != nil {
+		t.Errorf("expected Fire to not return error: %s", err)
+	}
+	if sent := h.Stats().Sent; sent != 2 {
+		t.Errorf("expected the 2 entries at the hook levels to be fired but got %d", sent)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("expected Close to not return error: %s", err)
+	}
+}
+
+func TestTestHook(t *testing.T) {
+	h, buffer := NewTestHook(WithFieldFilter([]string{"secret"}))
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	log.Hooks.Add(h)
+	log.WithField("user", "walrus").Info("first")
+	log.WithField("secret", "s3cr3t").Warn("second\nline")
+	buffer.Write([]byte("not json\n"))
+
+	entries := buffer.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries but got %#v", entries)
+	}
+	if entries[0]["message"] != "first" || entries[0]["user"] != "walrus" || entries[0]["level"] != "info" {
+		t.Errorf("expected the first entry to be decoded but got %#v", entries[0])
+	}
+	if _, ok := entries[1]["secret"]; ok || entries[1]["message"] != "second\nline" {
+		t.Errorf("expected the second entry to be formatted with the options but got %#v", entries[1])
+	}
+
+	buffer.Reset()
+	if entries := buffer.Entries(); len(entries) != 0 {
+		t.Errorf("expected no entries after Reset but got %#v", entries)
+	}
+}
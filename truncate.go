@@ -0,0 +1,92 @@
+package logrustash
+
+import (
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// truncatedKey is the field added to the entries whose message is truncated. See `WithMaxMessageBytes`.
+const truncatedKey = "_truncated"
+
+// WithMaxMessageBytes limits the size of the formatted entries to `n` bytes, e.g. for a Logstash input
+// which rejects larger events, by truncating their message until they fit.
+// The truncated entries have a "_truncated" field set to true, and they are still valid JSON.
+// An entry which is too large even with an empty message is not truncated further.
+func WithMaxMessageBytes(n int) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.maxMessageBytes = n
+	}
+}
+
+// truncateMessage truncates the message of `ne`, whose encoding doesn't fit in the formatter's
+// maximum size, to the longest prefix with which it fits and returns the encoding of the truncated entry.
+func (f LogstashFormatter) truncateMessage(ne *logrus.Entry) ([]byte, error) {
+	msg := ne.Message
+	ne.Data[truncatedKey] = true
+	// Every attempt is encoded to a new buffer since the result of the last fitting one is kept.
+	ne.Buffer = nil
+	// The size of the encoding of a message depends on its escaped characters,
+	// so the length of the prefix is found by a binary search.
+	var fit []byte
+	lo, hi := 0, len(msg)-1
+	for lo <= hi {
+		n := (lo + hi) / 2
+		ne.Message = truncateString(msg, n)
+		p, err := f.encode(ne)
+		if err != nil {
+			return nil, err
+		}
+		if len(p) <= f.maxMessageBytes {
+			fit = p
+			lo = n + 1
+		} else {
+			hi = n - 1
+		}
+	}
+	if fit == nil {
+		// The entry is too large even with an empty message.
+		ne.Message = ""
+		return f.encode(ne)
+	}
+	return fit, nil
+}
+
+// WithMaxFieldValueBytes truncates the string values of the fields of the entries which are longer
+// than `n` bytes, e.g. an HTTP response body, to their first `n` bytes followed by an ellipsis "…".
+// The field "<key>_truncated" is set to true for every truncated field "<key>".
+// The values which are not strings, like numbers, byte slices or objects, are not truncated, but the
+// strings of the objects flattened by `WithFlatten` and of the fields converted by `WithFieldTypes` are.
+func WithMaxFieldValueBytes(n int) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.maxFieldValueBytes = n
+	}
+}
+
+// truncateFields truncates the string values of `data` which are longer than `n` bytes.
+func truncateFields(data logrus.Fields, n int) {
+	var truncated []string
+	for k, v := range data {
+		if s, ok := v.(string); ok && len(s) > n {
+			data[k] = truncateString(s, n) + "…"
+			truncated = append(truncated, k)
+		}
+	}
+	for _, k := range truncated {
+		data[k+"_truncated"] = true
+	}
+}
+
+// truncateString returns the longest prefix of `s` of at most `n` bytes which doesn't split a UTF-8 character.
+func truncateString(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,90 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFormatterWithMaxMessageBytes(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{"app": "walrus"}, WithMaxMessageBytes(200))
+
+	res, err := formatter.Format(&logrus.Entry{Message: strings.Repeat("é\"x", 1000), Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if len(res) > 200 {
+		t.Errorf("expected the entry to be at most 200 bytes but got %d bytes", len(res))
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected the truncated entry to be valid JSON but got '%s': %s", string(res), err)
+	}
+	msg, _ := m["message"].(string)
+	if msg == "" || !strings.HasPrefix(strings.Repeat("é\"x", 1000), msg) {
+		t.Errorf("expected the message to be truncated but got %q", msg)
+	}
+	if m["_truncated"] != true || m["app"] != "walrus" {
+		t.Errorf("expected the truncation marker and the other fields in '%s'", string(res))
+	}
+
+	res, err = formatter.Format(&logrus.Entry{Message: "short", Data: logrus.Fields{}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	if strings.Contains(string(res), "_truncated") {
+		t.Errorf("expected a small entry to not be truncated but got '%s'", string(res))
+	}
+}
+
+func TestTruncateString(t *testing.T) {
+	testData := []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"hello", 3, "hel"},
+		{"hello", 10, "hello"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"hello", -1, ""},
+	}
+	for _, test := range testData {
+		if s := truncateString(test.s, test.n); s != test.expected {
+			t.Errorf("expected %q truncated to %d bytes to be %q but got %q", test.s, test.n, test.expected, s)
+		}
+	}
+}
+
+func TestFormatterWithMaxFieldValueBytes(t *testing.T) {
+	formatter := DefaultFormatter(logrus.Fields{}, WithMaxFieldValueBytes(1024))
+	body := strings.Repeat("0123456789", 1024)
+
+	res, err := formatter.Format(&logrus.Entry{Message: body, Data: logrus.Fields{
+		"body":   body,
+		"path":   "/users",
+		"status": 200,
+		"bytes":  []byte(body),
+	}})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(res, &m); err != nil {
+		t.Fatalf("expected Unmarshal to not return error: %s", err)
+	}
+
+	if m["body"] != body[:1024]+"…" || m["body_truncated"] != true {
+		t.Errorf("expected the body to be truncated to 1KB with an ellipsis but got %d bytes, truncated: %v", len(m["body"].(string)), m["body_truncated"])
+	}
+	if m["path"] != "/users" || m["status"] != float64(200) || m["message"] != body {
+		t.Errorf("expected the short fields, the other values and the message to be kept but got %v", m["path"])
+	}
+	for _, k := range []string{"path_truncated", "status_truncated", "bytes_truncated"} {
+		if _, ok := m[k]; ok {
+			t.Errorf("expected to not have '%s'", k)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package logrustash
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FieldType is the type a field is converted to by `WithFieldTypes`.
+type FieldType int
+
+const (
+	// TypeString converts a value to its string form: the message of an error,
+	// the result of a `String()` method or else the "%v" format.
+	TypeString FieldType = iota + 1
+	// TypeInt converts a number without a fractional part or a string parsed by strconv.ParseInt to an int64.
+	TypeInt
+	// TypeFloat converts a number or a string parsed by strconv.ParseFloat to a float64.
+	TypeFloat
+	// TypeBool converts a string parsed by strconv.ParseBool to a bool.
+	TypeBool
+)
+
+// WithFieldTypes converts the fields of the entries to the type of their key in `types`,
+// e.g. so a "status" field which is sometimes a string doesn't cause an Elasticsearch mapping conflict.
+// A field which can't be converted is removed, and the error is reported to the handler set by `OnFormatError`.
+// The keys are the keys of the entry data, before they are renamed by `FormatterConfig.KeyMap`.
+func WithFieldTypes(types map[string]FieldType) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.fieldTypes = make(map[string]FieldType, len(types))
+		for k, t := range types {
+			f.fieldTypes[k] = t
+		}
+	}
+}
+
+// convertFields converts the fields of `e` as described by `WithFieldTypes`.
+func (f LogstashFormatter) convertFields(e *logrus.Entry) {
+	for k, t := range f.fieldTypes {
+		v, ok := e.Data[k]
+		if !ok {
+			continue
+		}
+		if cv, ok := convertValue(v, t); ok {
+			e.Data[k] = cv
+		} else {
+			delete(e.Data, k)
+			f.reportError(e, fmt.Errorf("logrustash: field %q is removed because %#v can't be converted to its type", k, v))
+		}
+	}
+}
+
+// convertValue returns `v` converted to the type `t` and whether it could be converted.
+func convertValue(v interface{}, t FieldType) (interface{}, bool) {
+	if s, ok := v.(json.Number); ok {
+		v = string(s)
+	}
+	switch t {
+	case TypeString:
+		switch v := v.(type) {
+		case string:
+			return v, true
+		case error:
+			return v.Error(), true
+		case fmt.Stringer:
+			return v.String(), true
+		}
+		return fmt.Sprint(v), true
+	case TypeInt:
+		if s, ok := v.(string); ok {
+			i, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+			return i, err == nil
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return rv.Int(), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return int64(rv.Uint()), rv.Uint() <= math.MaxInt64
+		case reflect.Float32, reflect.Float64:
+			f := rv.Float()
+			return int64(f), f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64
+		}
+	case TypeFloat:
+		if s, ok := v.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			return f, err == nil
+		}
+		rv := reflect.ValueOf(v)
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return float64(rv.Int()), true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return float64(rv.Uint()), true
+		case reflect.Float32, reflect.Float64:
+			return rv.Float(), true
+		}
+	case TypeBool:
+		switch v := v.(type) {
+		case bool:
+			return v, true
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			return b, err == nil
+		}
+	}
+	return nil, false
+}
+
+// DurationFormat is the format of the time.Duration fields set by `WithDurationFormat`.
+type DurationFormat int
+
+const (
+	// DurationNanoseconds formats a duration as its integer number of nanoseconds, like encoding/json. It is the default.
+	DurationNanoseconds DurationFormat = iota
+	// DurationMilliseconds formats a duration as its number of milliseconds, e.g. 1.5 for 1500µs.
+	DurationMilliseconds
+	// DurationSeconds formats a duration as its number of seconds, e.g. 0.15 for 150ms.
+	DurationSeconds
+	// DurationString formats a duration as a string by its `String` method, e.g. "150ms".
+	DurationString
+)
+
+// WithDurationFormat sets the format of the fields of the entries whose value is a time.Duration,
+// e.g. `DurationMilliseconds` so a "latency" field of 150ms is formatted as 150.
+// Only the fields of the entry data are formatted, not the values nested in them, unless they are
+// flattened by `WithFlatten`.
+func WithDurationFormat(df DurationFormat) FormatterOption {
+	return func(f *LogstashFormatter) {
+		f.durationFormat = df
+	}
+}
+
+// formatDurations formats the time.Duration fields of `data` as `df`.
+func formatDurations(data logrus.Fields, df DurationFormat) {
+	for k, v := range data {
+		d, ok := v.(time.Duration)
+		if !ok {
+			continue
+		}
+		switch df {
+		case DurationMilliseconds:
+			data[k] = float64(d) / float64(time.Millisecond)
+		case DurationSeconds:
+			data[k] = d.Seconds()
+		case DurationString:
+			data[k] = d.String()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package logrustash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFormatterWithFieldTypes(t *testing.T) {
+	var reported []string
+	formatter := DefaultFormatter(logrus.Fields{}, OnFormatError(func(e *logrus.Entry, err error) {
+		reported = append(reported, err.Error())
+	}), WithFieldTypes(map[string]FieldType{
+		"status":  TypeInt,
+		"latency": TypeFloat,
+		"cached":  TypeBool,
+		"code":    TypeString,
+		"err":     TypeString,
+		"bad":     TypeInt,
+		"missing": TypeInt,
+	}))
+
+	res, err := formatter.Format(&logrus.Entry{
+		Data: logrus.Fields{
+			"status":  "404",
+			"latency": 12,
+			"cached":  "true",
+			"code":    500,
+			"err":     errors.New("boom"),
+			"bad":     "not a number",
+			"other":   "7",
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected Format to not return error: %s", err)
+	}
+	expected := []string{
+		`"status":404`,
+		`"latency":12`,
+		`"cached":true`,
+		`"code":"500"`,
+		`"err":"boom"`,
+		`"other":"7"`,
+	}
+	for _, exp := range expected {
+		if !strings.Contains(string(res), exp) {
+			t.Errorf("expected to have '%s' in '%s'", exp, string(res))
+		}
+	}
+	for _, unexp := range []string{`"bad"`, `"missing"`} {
+		if strings.Contains(string(res), unexp) {
+			t.Errorf("expected to not have '%s' in '%s'", unexp, string(res))
+		}
+	}
+	if len(reported) != 1 || !strings.Contains(reported[0], `"bad"`) {
+		t.Errorf("expected the removed field to be reported but got %v", reported)
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	testData := []struct {
+		value    interface{}
+		typ      FieldType
+		expected interface{}
+		ok       bool
+	}{
+		{" 42 ", TypeInt, int64(42), true},
+		{uint8(3), TypeInt, int64(3), true},
+		{2.0, TypeInt, int64(2), true},
+		{2.5, TypeInt, nil, false},
+		{uint64(1 << 63), TypeInt, nil, false},
+		{true, TypeInt, nil, false},
+		{"1.5", TypeFloat, 1.5, true},
+		{int64(-1), TypeFloat, float64(-1), true},
+		{"x", TypeFloat, nil, false},
+		{false, TypeBool, false, true},
+		{"0", TypeBool, false, true},
+		{1, TypeBool, nil, false},
+		{1.5, TypeString, "1.5", true},
+	}
+
+	for _, test := range testData {
+		v, ok := convertValue(test.value, test.typ)
+		if ok != test.ok || (ok && v != test.expected) {
+			t.Errorf("expected %#v converted to %d to be %#v (%t) but got %#v (%t)", test.value, test.typ, test.expected, test.ok, v, ok)
+		}
+	}
+}
+
+func TestFormatterWithDurationFormat(t *testing.T) {
+	testData := []struct {
+		format   DurationFormat
+		expected string
+	}{
+		{DurationNanoseconds, `"latency":150000000`},
+		{DurationMilliseconds, `"latency":150`},
+		{DurationSeconds, `"latency":0.15`},
+		{DurationString, `"latency":"150ms"`},
+	}
+
+	for _, test := range testData {
+		formatter := DefaultFormatter(logrus.Fields{}, WithDurationFormat(test.format))
+		res, err := formatter.Format(&logrus.Entry{Data: logrus.Fields{"latency": 150 * time.Millisecond, "count": 3}})
+		if err != nil {
+			t.Fatalf("expected Format to not return error: %s", err)
+		}
+		if !strings.Contains(string(res), test.expected) || !strings.Contains(string(res), `"count":3`) {
+			t.Errorf("expected to have '%s' and the other fields unchanged in '%s'", test.expected, string(res))
+		}
+	}
+}
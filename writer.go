@@ -0,0 +1,61 @@
+package logrustash
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NewLogstashWriter returns an io.Writer which sends what is written to it to Logstash through `w`,
+// for the code which only accepts an io.Writer and can't register a hook, e.g. `logrus.SetOutput`
+// or the standard `log` package. Every write is sent as an info entry whose message is the written
+// text without its trailing newline, formatted by `f`. If `f` is nil, the written bytes are sent
+// as they are, e.g. when they are already formatted by the formatter of a logrus logger:
+//
+// logger.SetOutput(logrustash.NewLogstashWriter(conn, nil))
+//
+// The entries are framed like the entries of a hook, terminated by a newline unless set
+// differently by `WithFraming`, and `opts` apply like they do to a hook.
+// The returned writer implements io.Closer, which closes `w`.
+func NewLogstashWriter(w io.Writer, f logrus.Formatter, opts ...HookOption) io.Writer {
+	lw := &logstashWriter{raw: f == nil}
+	if lw.raw {
+		f = messageFormatter{}
+	}
+	lw.hook = New(w, f, withStreamDefaults(opts)...)
+	return lw
+}
+
+// logstashWriter sends every write to Logstash as an entry. See `NewLogstashWriter`.
+type logstashWriter struct {
+	hook *Hook
+	// raw is true if the writes are sent as they are.
+	raw bool
+}
+
+// Write sends `p` to Logstash.
+func (lw *logstashWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	if !lw.raw {
+		msg = strings.TrimRight(msg, "\r\n")
+	}
+	e := &logrus.Entry{Data: logrus.Fields{}, Time: time.Now(), Level: logrus.InfoLevel, Message: msg}
+	if err := lw.hook.Fire(e); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the writer of the hook.
+func (lw *logstashWriter) Close() error {
+	return lw.hook.Close()
+}
+
+// messageFormatter formats an entry to its message.
+type messageFormatter struct{}
+
+func (messageFormatter) Format(e *logrus.Entry) ([]byte, error) {
+	return []byte(e.Message), nil
+}
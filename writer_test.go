@@ -0,0 +1,56 @@
+package logrustash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogstashWriter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	l := log.New(NewLogstashWriter(buffer, DefaultFormatter(logrus.Fields{"type": "lib"})), "", 0)
+	l.Print("connection opened")
+	l.Print("connection closed")
+
+	lines := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 entries but got '%s'", buffer.String())
+	}
+	for i, expected := range []string{"connection opened", "connection closed"} {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &m); err != nil {
+			t.Fatalf("expected Unmarshal to not return error: %s", err)
+		}
+		if m["message"] != expected || m["level"] != "info" || m["type"] != "lib" {
+			t.Errorf("expected an info entry with the message '%s' but got %v", expected, m)
+		}
+	}
+}
+
+func TestLogstashWriterRaw(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	w := NewLogstashWriter(buffer, nil, WithFraming(FramingLengthPrefix))
+	if _, err := io.WriteString(w, `{"message":"hello"}`); err != nil {
+		t.Fatalf("expected Write to not return error: %s", err)
+	}
+
+	p := buffer.Bytes()
+	if len(p) < 4 || binary.BigEndian.Uint32(p) != uint32(len(p)-4) || string(p[4:]) != `{"message":"hello"}` {
+		t.Errorf("expected the written bytes to be framed as they are but got %q", p)
+	}
+
+	newline := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.SetOutput(NewLogstashWriter(newline, nil))
+	logger.SetFormatter(DefaultFormatter(logrus.Fields{}))
+	logger.Info("hello")
+	if !strings.HasSuffix(newline.String(), "}\n") || strings.Count(newline.String(), "\n") != 1 {
+		t.Errorf("expected the entry to be terminated by one newline but got %q", newline.String())
+	}
+}